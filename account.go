@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// accountCommands are the subcommands of `contacts account`.
+var accountCommands []command
+
+func init() {
+	accountCommands = []command{
+		{"create", "create NAME    Create an account, printing its ID", runAccountCreate},
+		{"list", "list           List every account", runAccountList},
+		{"help", "help           Show this help", runAccountHelp},
+	}
+}
+
+func runAccount(args []string) int {
+	if len(args) == 0 {
+		printAccountUsage(os.Stderr)
+		return exitUsage
+	}
+
+	for _, cmd := range accountCommands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "contacts: unknown account command %q\n\n", args[0])
+	printAccountUsage(os.Stderr)
+	return exitUsage
+}
+
+func runAccountHelp(args []string) int {
+	printAccountUsage(os.Stdout)
+	return exitOK
+}
+
+func printAccountUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: contacts account COMMAND [ARGS...]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range accountCommands {
+		fmt.Fprintln(w, "  "+cmd.usage)
+	}
+}
+
+func runAccountCreate(args []string) int {
+	cfg, args, code := loadConfig("account create", args)
+	if cfg == nil {
+		return code
+	}
+	if len(args) != 1 || strings.TrimSpace(args[0]) == "" {
+		return usageError("usage: contacts account create [FLAGS] NAME")
+	}
+	if err := cfg.RequireDB(); err != nil {
+		return fail(err)
+	}
+	database := SetupDB(cfg)
+	defer database.Close()
+
+	account, err := database.CreateAccount(context.Background(), strings.TrimSpace(args[0]))
+	if err != nil {
+		return fail(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Account %q has ID:\n", account.Name)
+	fmt.Println(account.Id)
+	return exitOK
+}
+
+func runAccountList(args []string) int {
+	cfg, args, code := loadConfig("account list", args)
+	if cfg == nil {
+		return code
+	}
+	if len(args) != 0 {
+		return usageError("account list takes no arguments")
+	}
+	if err := cfg.RequireDB(); err != nil {
+		return fail(err)
+	}
+	database := SetupDB(cfg)
+	defer database.Close()
+
+	accounts, err := database.ListAccounts(context.Background())
+	if err != nil {
+		return fail(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tCREATED")
+	for _, a := range accounts {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", a.Id, a.Name, formatTime(&a.CreatedAt))
+	}
+	w.Flush()
+
+	return exitOK
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/circleci/cci-demo-docker/service"
+)
+
+// apiKeyCommands are the subcommands of `contacts apikey`.
+var apiKeyCommands []command
+
+func init() {
+	apiKeyCommands = []command{
+		{"create", "create -name NAME [-account ID] [-scopes S] [-expires D]  Create a key, printing it once", runAPIKeyCreate},
+		{"list", "list                                                      List every key, without revealing them", runAPIKeyList},
+		{"revoke", "revoke PREFIX                                             Revoke a key, e.g. ck_3f9a2b7c1d0e", runAPIKeyRevoke},
+		{"help", "help                                                      Show this help", runAPIKeyHelp},
+	}
+}
+
+func runAPIKey(args []string) int {
+	if len(args) == 0 {
+		printAPIKeyUsage(os.Stderr)
+		return exitUsage
+	}
+
+	for _, cmd := range apiKeyCommands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "contacts: unknown apikey command %q\n\n", args[0])
+	printAPIKeyUsage(os.Stderr)
+	return exitUsage
+}
+
+func runAPIKeyHelp(args []string) int {
+	printAPIKeyUsage(os.Stdout)
+	return exitOK
+}
+
+func printAPIKeyUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: contacts apikey COMMAND [ARGS...]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range apiKeyCommands {
+		fmt.Fprintln(w, "  "+cmd.usage)
+	}
+}
+
+func runAPIKeyCreate(args []string) int {
+	var name, scopeList string
+	var accountID int
+	var expires time.Duration
+	cfg, args, code := loadConfig("apikey create", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&name, "name", "", "what the key is for, e.g. the client using it")
+		fs.IntVar(&accountID, "account", service.DefaultAccountID, "ID of the account the key's callers belong to, see: contacts account list")
+		fs.StringVar(&scopeList, "scopes", "contacts:read,contacts:write", "comma separated scopes granted to the key")
+		fs.DurationVar(&expires, "expires", 0, "how long until the key expires, e.g. 720h, or 0 to never expire")
+	})
+	if cfg == nil {
+		return code
+	}
+	if len(args) != 0 || strings.TrimSpace(name) == "" {
+		return usageError("usage: contacts apikey create -name NAME [-account ID] [-scopes S] [-expires D] [FLAGS]")
+	}
+	if accountID <= 0 {
+		return usageError("apikey create: -account must be an account ID")
+	}
+	if expires < 0 {
+		return usageError("apikey create: -expires must not be negative")
+	}
+
+	scopes, err := service.ParseScopes(scopeList)
+	if err != nil {
+		return usageError("apikey create: %v", err)
+	}
+
+	var expiresAt *time.Time
+	if expires > 0 {
+		t := time.Now().Add(expires)
+		expiresAt = &t
+	}
+
+	if err := cfg.RequireDB(); err != nil {
+		return fail(err)
+	}
+	database := SetupDB(cfg)
+	defer database.Close()
+
+	key, apiKey, err := database.CreateAPIKey(context.Background(), accountID, strings.TrimSpace(name), scopes, expiresAt)
+	if err != nil {
+		return fail(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Created API key %v. It is only shown once, store it somewhere safe:\n", apiKey.Prefix)
+	fmt.Println(key)
+	return exitOK
+}
+
+func runAPIKeyList(args []string) int {
+	cfg, args, code := loadConfig("apikey list", args)
+	if cfg == nil {
+		return code
+	}
+	if len(args) != 0 {
+		return usageError("apikey list takes no arguments")
+	}
+	if err := cfg.RequireDB(); err != nil {
+		return fail(err)
+	}
+	database := SetupDB(cfg)
+	defer database.Close()
+
+	apiKeys, err := database.ListAPIKeys(context.Background())
+	if err != nil {
+		return fail(err)
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PREFIX\tACCOUNT\tNAME\tSCOPES\tCREATED\tEXPIRES\tLAST USED\tSTATUS")
+	for _, k := range apiKeys {
+		status := "active"
+		if k.RevokedAt != nil {
+			status = "revoked " + formatTime(k.RevokedAt)
+		} else if !k.Active(now) {
+			status = "expired"
+		}
+		fmt.Fprintf(
+			w,
+			"%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			k.Prefix,
+			k.AccountId,
+			k.Name,
+			strings.Join(k.Scopes, ","),
+			formatTime(&k.CreatedAt),
+			formatTime(k.ExpiresAt),
+			formatTime(k.LastUsedAt),
+			status,
+		)
+	}
+	w.Flush()
+
+	return exitOK
+}
+
+func runAPIKeyRevoke(args []string) int {
+	cfg, args, code := loadConfig("apikey revoke", args)
+	if cfg == nil {
+		return code
+	}
+	if len(args) != 1 {
+		return usageError("usage: contacts apikey revoke [FLAGS] PREFIX")
+	}
+	if err := cfg.RequireDB(); err != nil {
+		return fail(err)
+	}
+	database := SetupDB(cfg)
+	defer database.Close()
+
+	err := database.RevokeAPIKey(context.Background(), args[0])
+	if errors.Is(err, service.ErrAPIKeyNotFound) {
+		return fail(fmt.Errorf("No active API key with prefix %q", args[0]))
+	} else if err != nil {
+		return fail(err)
+	}
+
+	fmt.Printf("Revoked API key %v\n", args[0])
+	return exitOK
+}
+
+// formatTime formats an optional time for tables, as "-" when it is missing.
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+)
+
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		return usageError("usage: contacts config print [FLAGS]")
+	}
+
+	cfg, args, code := loadConfig("config print", args[1:])
+	if cfg == nil {
+		return code
+	}
+	if len(args) != 0 {
+		return usageError("config print takes no arguments")
+	}
+
+	out, err := cfg.Redacted().YAML()
+	if err != nil {
+		return fail(err)
+	}
+
+	os.Stdout.Write(out)
+	return exitOK
+}
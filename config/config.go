@@ -0,0 +1,614 @@
+// Package config loads the service's configuration. Settings are read from an optional YAML or TOML file, then
+// environment variables, then command line flags, with each source overriding the one before it.
+package config
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the complete configuration of the service.
+type Config struct {
+	ListenAddr string    `yaml:"listen_addr"`
+	HTTP       HTTP      `yaml:"http"`
+	TLS        TLS       `yaml:"tls"`
+	API        API       `yaml:"api"`
+	DB         DB        `yaml:"db"`
+	Log        Log       `yaml:"log"`
+	Tracing    Tracing   `yaml:"tracing"`
+	RateLimit  RateLimit `yaml:"rate_limit"`
+	Auth       Auth      `yaml:"auth"`
+	CORS       CORS      `yaml:"cors"`
+}
+
+// HTTP configures the HTTP server's timeouts and how it shuts down.
+type HTTP struct {
+	ReadTimeout  Duration `yaml:"read_timeout"`
+	WriteTimeout Duration `yaml:"write_timeout"`
+	IdleTimeout  Duration `yaml:"idle_timeout"`
+	// DrainDelay is how long readiness fails before the server stops accepting connections, giving load balancers
+	// time to notice.
+	DrainDelay Duration `yaml:"drain_delay"`
+	// ShutdownTimeout is how long in-flight requests may take to finish once the server stops accepting connections.
+	ShutdownTimeout Duration `yaml:"shutdown_timeout"`
+	// MaxBodySize is the largest request body accepted, in bytes.
+	MaxBodySize int `yaml:"max_body_size"`
+}
+
+// API configures the versioned HTTP API.
+type API struct {
+	// LegacyDeprecation is the date, like 2026-10-18, from which unversioned paths are deprecated. The service's
+	// default is used when it is empty.
+	LegacyDeprecation string `yaml:"legacy_deprecation"`
+	// LegacySunset is the date, like 2027-04-18, after which unversioned paths may be removed. The service's default
+	// is used when it is empty.
+	LegacySunset string `yaml:"legacy_sunset"`
+
+	// ValidateRequests rejects requests that don't match the OpenAPI spec served at /openapi.json before they reach a
+	// handler.
+	ValidateRequests bool `yaml:"validate_requests"`
+}
+
+// LegacyDeprecationTime parses LegacyDeprecation, returning the zero time if it is empty.
+func (a API) LegacyDeprecationTime() (time.Time, error) {
+	return parseDate(a.LegacyDeprecation)
+}
+
+// LegacySunsetTime parses LegacySunset, returning the zero time if it is empty.
+func (a API) LegacySunsetTime() (time.Time, error) {
+	return parseDate(a.LegacySunset)
+}
+
+func parseDate(date string) (time.Time, error) {
+	if date == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse("2006-01-02", date)
+}
+
+// TLS configures serving HTTPS. It is enabled when a certificate and key are given.
+type TLS struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ReloadInterval is how often the certificate and key files are checked for changes.
+	ReloadInterval Duration `yaml:"reload_interval"`
+	// ClientAuth is "none", "optional" to verify client certificates when presented, or "require".
+	ClientAuth string `yaml:"client_auth"`
+	// ClientCAFile is a PEM bundle of the CAs that client certificates are verified against.
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+// Enabled reports whether HTTPS should be served.
+func (t TLS) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// DB configures the database connection, its pool, and migrations.
+type DB struct {
+	// URL is what the service connects with. It should be a role that doesn't own the tables, like a member of the
+	// contacts_app role, so that row-level security applies to it.
+	URL string `yaml:"url"`
+	// MigrationURL is what migrations are run with, as the role that owns the tables. URL is used when it is empty.
+	MigrationURL string `yaml:"migration_url"`
+	// Migrations is a directory of SQL migrations. The migrations embedded in the binary are used when it is empty.
+	Migrations           string   `yaml:"migrations"`
+	MigrationLockTimeout Duration `yaml:"migration_lock_timeout"`
+	// SchemaCheck fails readiness at startup if the schema has drifted from the migrations.
+	SchemaCheck bool `yaml:"schema_check"`
+	// AllowRLSBypass lets the service start when row-level security doesn't apply to the role of URL, e.g. with a
+	// development database that has a single role. Accounts are then only kept apart by the filters of the queries.
+	AllowRLSBypass  bool     `yaml:"allow_rls_bypass"`
+	MaxOpenConns    int      `yaml:"max_open_conns"`
+	MaxIdleConns    int      `yaml:"max_idle_conns"`
+	ConnMaxLifetime Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime Duration `yaml:"conn_max_idle_time"`
+}
+
+// Log configures logging.
+type Log struct {
+	// Output is "stderr", "stdout", or the path of a file to append to.
+	Output string `yaml:"output"`
+	// Format is "json", or "text" for logs that are easier to read in a terminal.
+	Format string `yaml:"format"`
+	// Level is the least severe level logged: "debug", "info", "warn" or "error".
+	Level string `yaml:"level"`
+	// SlowQueryThreshold logs statements that take at least this long. Zero disables slow query logging.
+	SlowQueryThreshold Duration `yaml:"slow_query_threshold"`
+}
+
+// SlogLevel parses Level.
+func (l Log) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(l.Level)); err != nil {
+		return 0, fmt.Errorf("log.level must be debug, info, warn or error, got %q", l.Level)
+	}
+
+	return level, nil
+}
+
+// Tracing configures exporting the spans of each request, see package tracing.
+type Tracing struct {
+	// Exporter is "none", "stdout", "file" to append to File, or "otlp" to send to Endpoint.
+	Exporter string `yaml:"exporter"`
+	File     string `yaml:"file"`
+	// Endpoint is the URL of an OTLP/HTTP traces receiver.
+	Endpoint    string `yaml:"endpoint"`
+	ServiceName string `yaml:"service_name"`
+}
+
+// Auth configures how requests to the API are authenticated. Routes outside the API, like /healthz, are public.
+type Auth struct {
+	// APIKeys authenticates requests with the API keys managed by `contacts apikey`. Every request to the API must be
+	// authenticated when it is enabled.
+	APIKeys bool `yaml:"api_keys"`
+	JWT     JWT  `yaml:"jwt"`
+}
+
+// JWT configures authenticating with JSON Web Tokens signed with RS256 or ES256, like those of an OIDC provider. It is
+// enabled when an issuer is given.
+type JWT struct {
+	// Issuer must match the iss claim of tokens.
+	Issuer string `yaml:"issuer"`
+	// Audience must be in the aud claim of tokens.
+	Audience string `yaml:"audience"`
+	// JWKSFile or JWKSURL is where the issuer's public keys are loaded from, e.g. its jwks_uri.
+	JWKSFile string `yaml:"jwks_file"`
+	JWKSURL  string `yaml:"jwks_url"`
+	// JWKSRefreshInterval is how often the keys are reloaded.
+	JWKSRefreshInterval Duration `yaml:"jwks_refresh_interval"`
+	// ClockSkew is how far the issuer's clock may be from the service's, when checking expiry.
+	ClockSkew Duration `yaml:"clock_skew"`
+	// AccountClaim names the claim holding the ID of the caller's account. When empty, every caller belongs to the
+	// default account.
+	AccountClaim string `yaml:"account_claim"`
+}
+
+// Enabled reports whether JWTs are accepted.
+func (j JWT) Enabled() bool {
+	return j.Issuer != ""
+}
+
+// CORS configures which origins browsers may call the API from. Origins are like "https://dashboard.example.xyz", or
+// wildcards like "https://*.example.xyz" matching any subdomain, or "*" for every origin. It is disabled when no origins
+// are allowed. The service's defaults are used when no methods or headers are given.
+type CORS struct {
+	AllowedOrigins   []string `yaml:"allowed_origins"`
+	AllowedMethods   []string `yaml:"allowed_methods"`
+	AllowedHeaders   []string `yaml:"allowed_headers"`
+	AllowCredentials bool     `yaml:"allow_credentials"`
+	// MaxAge is how long browsers may cache the response to a preflight request.
+	MaxAge Duration `yaml:"max_age"`
+}
+
+// Enabled reports whether any origin is allowed.
+func (c CORS) Enabled() bool {
+	return len(c.AllowedOrigins) > 0
+}
+
+// checkOrigin returns an error if origin isn't an origin, a wildcard origin, or "*".
+func checkOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+
+	scheme, host, ok := strings.Cut(origin, "://")
+	if !ok || scheme == "" || host == "" || strings.ContainsAny(host, "/?#") {
+		return fmt.Errorf("cors.allowed_origins must be origins like https://example.xyz, got %q", origin)
+	}
+	if strings.Contains(host, "*") && (!strings.HasPrefix(host, "*.") || strings.Count(host, "*") > 1) {
+		return fmt.Errorf("cors.allowed_origins may only start with a wildcard, like https://*.example.xyz, got %q", origin)
+	}
+
+	return nil
+}
+
+// RateLimit configures limiting the rate of requests from each client. Limits are written like "100/1m", for 100
+// requests a minute, or "100/1m,burst=20" to allow at most 20 of them at once. The burst is the rate by default.
+type RateLimit struct {
+	// Store is "none" to disable rate limiting, "memory" to limit each replica separately, or "postgres" to share
+	// limits between replicas.
+	Store string `yaml:"store"`
+	// Default is the limit of every API route that isn't in Routes.
+	Default string `yaml:"default"`
+	// Routes overrides the limit of routes, keyed by method and route pattern, e.g. "POST /v1/contacts". Unversioned
+	// aliases share the limit of their v1 route.
+	Routes map[string]string `yaml:"routes"`
+	// Authentication is the limit of failed authentications from each IP address. Once it is exceeded, tokens aren't
+	// looked up until the address's bucket refills. It applies even when Store is "none", keeping buckets in memory.
+	Authentication string `yaml:"authentication"`
+}
+
+// Limit is a parsed rate limit, see RateLimit.
+type Limit struct {
+	Rate   int
+	Period time.Duration
+	Burst  int
+}
+
+// ParseLimit parses a limit like "100/1m" or "100/1m,burst=20".
+func ParseLimit(value string) (Limit, error) {
+	invalid := fmt.Errorf("%q is not a rate limit like 100/1m or 100/1m,burst=20", value)
+
+	var limit Limit
+	parts := strings.Split(value, ",")
+	rate := strings.SplitN(parts[0], "/", 2)
+	if len(rate) != 2 {
+		return limit, invalid
+	}
+
+	var err error
+	if limit.Rate, err = strconv.Atoi(strings.TrimSpace(rate[0])); err != nil || limit.Rate <= 0 {
+		return limit, invalid
+	}
+	if limit.Period, err = time.ParseDuration(strings.TrimSpace(rate[1])); err != nil || limit.Period <= 0 {
+		return limit, invalid
+	}
+
+	limit.Burst = limit.Rate
+	for _, option := range parts[1:] {
+		burst := strings.TrimPrefix(strings.TrimSpace(option), "burst=")
+		if burst == strings.TrimSpace(option) {
+			return limit, invalid
+		}
+		if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst <= 0 {
+			return limit, invalid
+		}
+	}
+
+	return limit, nil
+}
+
+// Limits parses the default limit and the limit of each route.
+func (r RateLimit) Limits() (Limit, map[string]Limit, error) {
+	defaultLimit, err := ParseLimit(r.Default)
+	if err != nil {
+		return Limit{}, nil, fmt.Errorf("rate_limit.default: %v", err)
+	}
+
+	routes := make([]string, 0, len(r.Routes))
+	for route := range r.Routes {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	limits := make(map[string]Limit, len(routes))
+	for _, route := range routes {
+		if limits[route], err = ParseLimit(r.Routes[route]); err != nil {
+			return Limit{}, nil, fmt.Errorf("rate_limit.routes[%q]: %v", route, err)
+		}
+	}
+
+	return defaultLimit, limits, nil
+}
+
+// Default returns the configuration used for any setting that isn't given.
+func Default() *Config {
+	return &Config{
+		ListenAddr: ":8080",
+		HTTP: HTTP{
+			ReadTimeout:     Duration(10 * time.Second),
+			WriteTimeout:    Duration(30 * time.Second),
+			IdleTimeout:     Duration(2 * time.Minute),
+			DrainDelay:      Duration(5 * time.Second),
+			ShutdownTimeout: Duration(25 * time.Second),
+			MaxBodySize:     1 << 20,
+		},
+		TLS: TLS{
+			ReloadInterval: Duration(30 * time.Second),
+			ClientAuth:     "none",
+		},
+		DB: DB{
+			MigrationLockTimeout: Duration(2 * time.Minute),
+			MaxOpenConns:         20,
+			MaxIdleConns:         5,
+			ConnMaxLifetime:      Duration(30 * time.Minute),
+			ConnMaxIdleTime:      Duration(5 * time.Minute),
+		},
+		Log: Log{
+			Output:             "stderr",
+			Format:             "json",
+			Level:              "info",
+			SlowQueryThreshold: Duration(200 * time.Millisecond),
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			Endpoint:    "http://localhost:4318/v1/traces",
+			ServiceName: "contacts",
+		},
+		RateLimit: RateLimit{
+			Store:          "none",
+			Default:        "100/1m",
+			Authentication: "20/1m",
+		},
+		Auth: Auth{
+			APIKeys: true,
+			JWT: JWT{
+				JWKSRefreshInterval: Duration(time.Hour),
+				ClockSkew:           Duration(time.Minute),
+			},
+		},
+	}
+}
+
+// Validate checks that the settings are consistent. A database URL isn't required, as some commands don't need one;
+// use RequireDB for those that do.
+func (c *Config) Validate() error {
+	if c.ListenAddr == "" {
+		return errors.New("listen_addr must not be empty")
+	}
+
+	if c.HTTP.ReadTimeout < 0 || c.HTTP.WriteTimeout < 0 || c.HTTP.IdleTimeout < 0 {
+		return errors.New("http.read_timeout, http.write_timeout and http.idle_timeout must not be negative")
+	}
+	if c.HTTP.DrainDelay < 0 {
+		return errors.New("http.drain_delay must not be negative")
+	}
+	if c.HTTP.ShutdownTimeout <= 0 {
+		return errors.New("http.shutdown_timeout must be positive")
+	}
+	if c.HTTP.MaxBodySize <= 0 {
+		return errors.New("http.max_body_size must be positive")
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return errors.New("tls.cert_file and tls.key_file must be set together")
+	}
+	if c.TLS.ReloadInterval <= 0 {
+		return errors.New("tls.reload_interval must be positive")
+	}
+	switch c.TLS.ClientAuth {
+	case "none":
+	case "optional", "require":
+		if !c.TLS.Enabled() || c.TLS.ClientCAFile == "" {
+			return fmt.Errorf("tls.client_auth %q needs tls.cert_file, tls.key_file and tls.client_ca_file", c.TLS.ClientAuth)
+		}
+	default:
+		return fmt.Errorf("tls.client_auth must be none, optional or require, got %q", c.TLS.ClientAuth)
+	}
+
+	if _, err := c.API.LegacyDeprecationTime(); err != nil {
+		return fmt.Errorf("api.legacy_deprecation must be a date like 2026-10-18, got %q", c.API.LegacyDeprecation)
+	}
+	if _, err := c.API.LegacySunsetTime(); err != nil {
+		return fmt.Errorf("api.legacy_sunset must be a date like 2027-04-18, got %q", c.API.LegacySunset)
+	}
+
+	for _, setting := range [][2]string{{"db.url", c.DB.URL}, {"db.migration_url", c.DB.MigrationURL}} {
+		name, dbURL := setting[0], setting[1]
+		if dbURL == "" {
+			continue
+		}
+		u, err := url.Parse(dbURL)
+		if err != nil {
+			return fmt.Errorf("%v is invalid: %v", name, err)
+		}
+		if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+			return fmt.Errorf("%v must be a postgres:// URL, got scheme %q", name, u.Scheme)
+		}
+	}
+	if c.DB.MigrationLockTimeout <= 0 {
+		return errors.New("db.migration_lock_timeout must be positive")
+	}
+	if c.DB.MaxOpenConns < 0 || c.DB.MaxIdleConns < 0 {
+		return errors.New("db.max_open_conns and db.max_idle_conns must not be negative")
+	}
+	if c.DB.MaxOpenConns > 0 && c.DB.MaxIdleConns > c.DB.MaxOpenConns {
+		return errors.New("db.max_idle_conns must not exceed db.max_open_conns")
+	}
+	if c.DB.ConnMaxLifetime < 0 || c.DB.ConnMaxIdleTime < 0 {
+		return errors.New("db.conn_max_lifetime and db.conn_max_idle_time must not be negative")
+	}
+
+	if c.Log.Output == "" {
+		return errors.New("log.output must not be empty")
+	}
+	switch c.Log.Format {
+	case "json", "text":
+	default:
+		return fmt.Errorf("log.format must be json or text, got %q", c.Log.Format)
+	}
+	if _, err := c.Log.SlogLevel(); err != nil {
+		return err
+	}
+	if c.Log.SlowQueryThreshold < 0 {
+		return errors.New("log.slow_query_threshold must not be negative")
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "file":
+		if c.Tracing.File == "" {
+			return errors.New("tracing.file must be set for the file exporter")
+		}
+	case "otlp":
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("tracing.endpoint must be an http:// or https:// URL, got %q", c.Tracing.Endpoint)
+		}
+	default:
+		return fmt.Errorf("tracing.exporter must be none, stdout, file or otlp, got %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.ServiceName == "" {
+		return errors.New("tracing.service_name must not be empty")
+	}
+
+	if c.Auth.JWT.Enabled() {
+		if c.Auth.JWT.Audience == "" {
+			return errors.New("auth.jwt.audience must be set with auth.jwt.issuer")
+		}
+		if (c.Auth.JWT.JWKSFile == "") == (c.Auth.JWT.JWKSURL == "") {
+			return errors.New("exactly one of auth.jwt.jwks_file and auth.jwt.jwks_url must be set with auth.jwt.issuer")
+		}
+		if u, err := url.Parse(c.Auth.JWT.JWKSURL); c.Auth.JWT.JWKSURL != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https")) {
+			return fmt.Errorf("auth.jwt.jwks_url must be an http:// or https:// URL, got %q", c.Auth.JWT.JWKSURL)
+		}
+	}
+	if c.Auth.JWT.JWKSRefreshInterval <= 0 {
+		return errors.New("auth.jwt.jwks_refresh_interval must be positive")
+	}
+	if c.Auth.JWT.ClockSkew < 0 {
+		return errors.New("auth.jwt.clock_skew must not be negative")
+	}
+
+	switch c.RateLimit.Store {
+	case "none", "memory", "postgres":
+	default:
+		return fmt.Errorf("rate_limit.store must be none, memory or postgres, got %q", c.RateLimit.Store)
+	}
+	if _, _, err := c.RateLimit.Limits(); err != nil {
+		return err
+	}
+	if _, err := ParseLimit(c.RateLimit.Authentication); err != nil {
+		return fmt.Errorf("rate_limit.authentication: %v", err)
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		if err := checkOrigin(origin); err != nil {
+			return err
+		}
+		if origin == "*" && c.CORS.AllowCredentials {
+			return errors.New("cors.allowed_origins must list the origins allowed credentials, instead of \"*\"")
+		}
+	}
+	if c.CORS.MaxAge < 0 {
+		return errors.New("cors.max_age must not be negative")
+	}
+
+	return nil
+}
+
+// RequireDB returns an error if no database URL is configured.
+func (c *Config) RequireDB() error {
+	if c.DB.URL == "" {
+		return errors.New("db.url must be set, e.g. with CONTACTS_DB_URL")
+	}
+
+	return nil
+}
+
+// OwnerURL is the URL to run migrations and schema checks with, as the role that owns the tables.
+func (d DB) OwnerURL() string {
+	if d.MigrationURL != "" {
+		return d.MigrationURL
+	}
+
+	return d.URL
+}
+
+// ConfigurePool applies the pool settings to db.
+func (c DB) ConfigurePool(db *sql.DB) {
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetConnMaxLifetime(time.Duration(c.ConnMaxLifetime))
+	db.SetConnMaxIdleTime(time.Duration(c.ConnMaxIdleTime))
+}
+
+// Redacted returns a copy of the configuration that is safe to print, with secrets replaced.
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	for _, s := range settings(&redacted) {
+		if s.secret {
+			s.redact()
+		}
+	}
+
+	return &redacted
+}
+
+// YAML renders the configuration in the same format as a config file.
+func (c *Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c)
+}
+
+// ===== LOADING =======================================================================================================
+
+// Loader loads a Config from its sources.
+type Loader struct {
+	// File is the path of an optional config file, YAML if it ends in .yaml or .yml, or TOML if it ends in .toml.
+	// CONTACTS_CONFIG is used when it is empty.
+	File string
+	// LookupEnv reads environment variables, and defaults to os.LookupEnv.
+	LookupEnv func(key string) (string, bool)
+	// Flags holds settings given on the command line, keyed by flag name.
+	Flags map[string]string
+}
+
+// Load returns the validated configuration.
+func (l *Loader) Load() (*Config, error) {
+	lookupEnv := l.LookupEnv
+	if lookupEnv == nil {
+		lookupEnv = os.LookupEnv
+	}
+
+	c := Default()
+
+	file := l.File
+	if file == "" {
+		file, _ = lookupEnv("CONTACTS_CONFIG")
+	}
+	if file != "" {
+		if err := loadFile(file, c); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings(c) {
+		if value, ok := lookupEnv(s.env); ok {
+			if err := s.set(value); err != nil {
+				return nil, fmt.Errorf("Invalid %v: %v", s.env, err)
+			}
+		}
+	}
+
+	for _, s := range settings(c) {
+		if value, ok := l.Flags[s.flag]; ok {
+			if err := s.set(value); err != nil {
+				return nil, fmt.Errorf("Invalid -%v: %v", s.flag, err)
+			}
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// loadFile reads the config file into c, choosing its format by its extension. Settings that c doesn't have are
+// rejected in either format, as they are probably misspelled. TOML keys are the same as YAML's, so both use the yaml
+// tags.
+func loadFile(file string, c *Config) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("Unable to read config file: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(content, c)
+	case ".toml":
+		err = toml.NewDecoder(bytes.NewReader(content)).SetTagName("yaml").Strict(true).Decode(c)
+	default:
+		return fmt.Errorf("Unable to load config file %v: its extension must be .yaml, .yml or .toml", file)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to parse config file %v: %v", file, err)
+	}
+
+	return nil
+}
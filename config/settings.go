@@ -0,0 +1,173 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting binds a single configuration value to its environment variable and command line flag.
+type setting struct {
+	env    string
+	flag   string
+	usage  string
+	secret bool
+	value  interface{}
+}
+
+// settings lists every setting that can be overridden by the environment or flags, bound to the fields of c.
+func settings(c *Config) []setting {
+	return []setting{
+		{"CONTACTS_LISTEN_ADDR", "listen-addr", "address to serve HTTP on", false, &c.ListenAddr},
+		{"CONTACTS_HTTP_READ_TIMEOUT", "http-read-timeout", "maximum time to read a request, 0 for none", false, &c.HTTP.ReadTimeout},
+		{"CONTACTS_HTTP_WRITE_TIMEOUT", "http-write-timeout", "maximum time to write a response, 0 for none", false, &c.HTTP.WriteTimeout},
+		{"CONTACTS_HTTP_IDLE_TIMEOUT", "http-idle-timeout", "maximum time to keep an idle connection open, 0 for none", false, &c.HTTP.IdleTimeout},
+		{"CONTACTS_HTTP_DRAIN_DELAY", "http-drain-delay", "how long readiness fails before shutting down", false, &c.HTTP.DrainDelay},
+		{"CONTACTS_HTTP_SHUTDOWN_TIMEOUT", "http-shutdown-timeout", "how long in-flight requests may take to finish on shutdown", false, &c.HTTP.ShutdownTimeout},
+		{"CONTACTS_HTTP_MAX_BODY_SIZE", "http-max-body-size", "largest request body accepted, in bytes", false, &c.HTTP.MaxBodySize},
+		{"CONTACTS_TLS_CERT_FILE", "tls-cert-file", "PEM certificate to serve HTTPS with", false, &c.TLS.CertFile},
+		{"CONTACTS_TLS_KEY_FILE", "tls-key-file", "PEM private key for tls-cert-file", false, &c.TLS.KeyFile},
+		{"CONTACTS_TLS_RELOAD_INTERVAL", "tls-reload-interval", "how often to check the certificate files for changes", false, &c.TLS.ReloadInterval},
+		{"CONTACTS_TLS_CLIENT_AUTH", "tls-client-auth", "none, optional or require client certificates", false, &c.TLS.ClientAuth},
+		{"CONTACTS_TLS_CLIENT_CA_FILE", "tls-client-ca-file", "PEM bundle of CAs to verify client certificates against", false, &c.TLS.ClientCAFile},
+		{"CONTACTS_API_LEGACY_DEPRECATION", "api-legacy-deprecation", "date from which unversioned paths are deprecated", false, &c.API.LegacyDeprecation},
+		{"CONTACTS_API_LEGACY_SUNSET", "api-legacy-sunset", "date after which unversioned paths may be removed", false, &c.API.LegacySunset},
+		{"CONTACTS_API_VALIDATE_REQUESTS", "api-validate-requests", "reject requests that don't match /openapi.json", false, &c.API.ValidateRequests},
+		{"CONTACTS_DB_URL", "db-url", "postgres:// URL of the database", true, &c.DB.URL},
+		{"CONTACTS_DB_MIGRATION_URL", "db-migration-url", "postgres:// URL of the role owning the tables, for migrations (default db.url)", true, &c.DB.MigrationURL},
+		{"CONTACTS_DB_MIGRATIONS", "db-migrations", "directory of SQL migrations, instead of the embedded ones", false, &c.DB.Migrations},
+		{"CONTACTS_DB_MIGRATION_LOCK_TIMEOUT", "db-migration-lock-timeout", "how long to wait for another replica's migrations", false, &c.DB.MigrationLockTimeout},
+		{"CONTACTS_DB_SCHEMA_CHECK", "db-schema-check", "fail readiness if the schema drifted from the migrations", false, &c.DB.SchemaCheck},
+		{"CONTACTS_DB_ALLOW_RLS_BYPASS", "db-allow-rls-bypass", "start even if row-level security doesn't apply to db.url's role", false, &c.DB.AllowRLSBypass},
+		{"CONTACTS_DB_MAX_OPEN_CONNS", "db-max-open-conns", "maximum open database connections, 0 for unlimited", false, &c.DB.MaxOpenConns},
+		{"CONTACTS_DB_MAX_IDLE_CONNS", "db-max-idle-conns", "maximum idle database connections", false, &c.DB.MaxIdleConns},
+		{"CONTACTS_DB_CONN_MAX_LIFETIME", "db-conn-max-lifetime", "maximum age of a database connection", false, &c.DB.ConnMaxLifetime},
+		{"CONTACTS_DB_CONN_MAX_IDLE_TIME", "db-conn-max-idle-time", "maximum idle time of a database connection", false, &c.DB.ConnMaxIdleTime},
+		{"CONTACTS_LOG_OUTPUT", "log-output", "stderr, stdout, or a file to append logs to", false, &c.Log.Output},
+		{"CONTACTS_LOG_FORMAT", "log-format", "json or text", false, &c.Log.Format},
+		{"CONTACTS_LOG_LEVEL", "log-level", "debug, info, warn or error", false, &c.Log.Level},
+		{"CONTACTS_LOG_SLOW_QUERY_THRESHOLD", "log-slow-query-threshold", "log statements slower than this, 0 to disable", false, &c.Log.SlowQueryThreshold},
+		{"CONTACTS_TRACING_EXPORTER", "tracing-exporter", "none, stdout, file or otlp", false, &c.Tracing.Exporter},
+		{"CONTACTS_TRACING_FILE", "tracing-file", "file the file exporter appends spans to", false, &c.Tracing.File},
+		{"CONTACTS_TRACING_ENDPOINT", "tracing-endpoint", "URL of an OTLP/HTTP traces receiver", true, &c.Tracing.Endpoint},
+		{"CONTACTS_TRACING_SERVICE_NAME", "tracing-service-name", "service name spans are exported with", false, &c.Tracing.ServiceName},
+		{"CONTACTS_RATE_LIMIT_STORE", "rate-limit-store", "none, memory or postgres", false, &c.RateLimit.Store},
+		{"CONTACTS_RATE_LIMIT_DEFAULT", "rate-limit-default", "requests per client to each API route, like 100/1m or 100/1m,burst=20", false, &c.RateLimit.Default},
+		{"CONTACTS_RATE_LIMIT_AUTHENTICATION", "rate-limit-authentication", "failed authentications per IP address, like 20/1m", false, &c.RateLimit.Authentication},
+		{"CONTACTS_AUTH_API_KEYS", "auth-api-keys", "require requests to the API to be authenticated with an API key", false, &c.Auth.APIKeys},
+		{"CONTACTS_AUTH_JWT_ISSUER", "auth-jwt-issuer", "accept JWTs from this issuer, matched against the iss claim", false, &c.Auth.JWT.Issuer},
+		{"CONTACTS_AUTH_JWT_AUDIENCE", "auth-jwt-audience", "audience JWTs must be issued for", false, &c.Auth.JWT.Audience},
+		{"CONTACTS_AUTH_JWT_JWKS_FILE", "auth-jwt-jwks-file", "file holding the issuer's JWKS", false, &c.Auth.JWT.JWKSFile},
+		{"CONTACTS_AUTH_JWT_JWKS_URL", "auth-jwt-jwks-url", "URL of the issuer's JWKS", false, &c.Auth.JWT.JWKSURL},
+		{"CONTACTS_AUTH_JWT_JWKS_REFRESH_INTERVAL", "auth-jwt-jwks-refresh-interval", "how often the JWKS is reloaded", false, &c.Auth.JWT.JWKSRefreshInterval},
+		{"CONTACTS_AUTH_JWT_CLOCK_SKEW", "auth-jwt-clock-skew", "allowed difference between the issuer's clock and ours", false, &c.Auth.JWT.ClockSkew},
+		{"CONTACTS_AUTH_JWT_ACCOUNT_CLAIM", "auth-jwt-account-claim", "claim holding the caller's account ID, or empty for the default account", false, &c.Auth.JWT.AccountClaim},
+		{"CONTACTS_CORS_ALLOWED_ORIGINS", "cors-allowed-origins", "comma separated origins browsers may call the API from, like https://*.example.xyz", false, &c.CORS.AllowedOrigins},
+		{"CONTACTS_CORS_ALLOWED_METHODS", "cors-allowed-methods", "comma separated methods browsers may use", false, &c.CORS.AllowedMethods},
+		{"CONTACTS_CORS_ALLOWED_HEADERS", "cors-allowed-headers", "comma separated request headers browsers may send", false, &c.CORS.AllowedHeaders},
+		{"CONTACTS_CORS_ALLOW_CREDENTIALS", "cors-allow-credentials", "let browsers send credentials to the allowed origins", false, &c.CORS.AllowCredentials},
+		{"CONTACTS_CORS_MAX_AGE", "cors-max-age", "how long browsers may cache preflight responses", false, &c.CORS.MaxAge},
+	}
+}
+
+func (s setting) set(value string) error {
+	switch v := s.value.(type) {
+	case *string:
+		*v = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", value)
+		}
+		*v = n
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", value)
+		}
+		*v = b
+	case *[]string:
+		*v = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*v = append(*v, item)
+			}
+		}
+	case *Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("expected a duration like 30s, got %q", value)
+		}
+		*v = Duration(d)
+	default:
+		panic(fmt.Sprintf("config: unsupported setting type %T", s.value))
+	}
+
+	return nil
+}
+
+// redact replaces the setting's value with one that is safe to print. The password is removed from URLs so that the
+// rest remains useful.
+func (s setting) redact() {
+	v, ok := s.value.(*string)
+	if !ok || *v == "" {
+		return
+	}
+
+	if u, err := url.Parse(*v); err == nil && u.Scheme != "" {
+		*v = u.Redacted()
+	} else {
+		*v = "REDACTED"
+	}
+}
+
+// BindFlags defines a flag on fs for every setting, plus -config for the config file. Once fs is parsed, the returned
+// Loader loads the configuration including any flags that were given.
+func BindFlags(fs *flag.FlagSet) *Loader {
+	l := &Loader{Flags: make(map[string]string)}
+
+	fs.StringVar(&l.File, "config", "", "path of a YAML or TOML config file (env CONTACTS_CONFIG)")
+	for _, s := range settings(Default()) {
+		name := s.flag
+		fs.Func(name, fmt.Sprintf("%v (env %v)", s.usage, s.env), func(value string) error {
+			l.Flags[name] = value
+			return nil
+		})
+	}
+
+	return l
+}
+
+// Duration is a time.Duration written as a string like "30s" in config files.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	return d.UnmarshalText([]byte(s))
+}
+
+// UnmarshalText parses a duration like 30s, e.g. from a TOML config file.
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("expected a duration like 30s, got %q", text)
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
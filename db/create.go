@@ -0,0 +1,44 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var migrationNameRegex = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Create scaffolds empty up and down files for a new migration in migrationsPath, numbered after the latest existing
+// migration. The paths of the created files are returned.
+func Create(migrationsPath, name string) (upFile string, downFile string, err error) {
+	if !migrationNameRegex.MatchString(name) {
+		return "", "", fmt.Errorf("Invalid migration name %q: use lowercase letters, digits and underscores", name)
+	}
+
+	latest, err := LatestVersion(migrationsPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	base := filepath.Join(migrationsPath, fmt.Sprintf("%03d_%s", latest+1, name))
+	upFile = base + ".up.sql"
+	downFile = base + ".down.sql"
+
+	for _, path := range []string{upFile, downFile} {
+		if _, err := os.Stat(path); err == nil {
+			return "", "", fmt.Errorf("Migration file %v already exists", path)
+		}
+	}
+
+	if err := ioutil.WriteFile(upFile, []byte("-- "+name+" (up)\n"), 0644); err != nil {
+		return "", "", err
+	}
+	if err := ioutil.WriteFile(downFile, []byte("-- "+name+" (down)\n"), 0644); err != nil {
+		os.Remove(upFile)
+		return "", "", err
+	}
+
+	return upFile, downFile, nil
+}
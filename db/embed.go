@@ -0,0 +1,97 @@
+package db
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// embedded holds the SQL migrations compiled into the binary. They are used whenever no migrations directory is given.
+//
+//go:embed migrations/*.sql
+var embedded embed.FS
+
+// MigrationFileInfo describes one migration file and its contents' checksum.
+type MigrationFileInfo struct {
+	Name   string
+	Size   int
+	SHA256 string
+}
+
+// Embedded returns the migrations compiled into the binary.
+func Embedded() fs.FS {
+	sub, err := fs.Sub(embedded, "migrations")
+	if err != nil {
+		panic(err) // The directory is part of the go:embed pattern, so it always exists.
+	}
+
+	return sub
+}
+
+// List describes each migration file in migrationsPath, or the embedded migrations if migrationsPath is empty.
+func List(migrationsPath string) ([]MigrationFileInfo, error) {
+	fsys := Embedded()
+	if migrationsPath != "" {
+		fsys = os.DirFS(migrationsPath)
+	}
+
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	infos := make([]MigrationFileInfo, 0, len(names))
+	for _, name := range names {
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256(content)
+		infos = append(infos, MigrationFileInfo{
+			Name:   name,
+			Size:   len(content),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+	}
+
+	return infos, nil
+}
+
+// resolve returns a directory the migrate library can read migrations from. The embedded migrations are written to a
+// temporary directory when migrationsPath is empty; cleanup removes it again.
+func resolve(migrationsPath string) (dir string, cleanup func(), err error) {
+	if migrationsPath != "" {
+		return migrationsPath, func() {}, nil
+	}
+
+	dir, err = ioutil.TempDir("", "contacts-migrations")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+
+	err = fs.WalkDir(Embedded(), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		content, err := fs.ReadFile(Embedded(), path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dir, path), content, 0644)
+	})
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	return dir, cleanup, nil
+}
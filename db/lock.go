@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// MigrationLockKey identifies the Postgres advisory lock held while migrations run. It is below 2^31, so pg_locks
+// reports it in objid with a classid of 0.
+const MigrationLockKey = 427531968
+
+// LockTimeout is how long to wait for another process to finish migrating before giving up.
+var LockTimeout = 2 * time.Minute
+
+// lockPollInterval is how often the lock is retried, and lockLogInterval how often a waiting process logs who it is
+// waiting on.
+const (
+	lockPollInterval = 500 * time.Millisecond
+	lockLogInterval  = 10 * time.Second
+)
+
+// LockTimeoutError is returned when the migration lock couldn't be acquired within LockTimeout.
+type LockTimeoutError struct {
+	Timeout time.Duration
+	Holder  string
+}
+
+func (e *LockTimeoutError) Error() string {
+	return fmt.Sprintf("Timed out after %v waiting for the migration lock held by %v", e.Timeout, e.Holder)
+}
+
+// withLock calls fn while holding the migration advisory lock, so that replicas starting at the same time don't run
+// migrations concurrently. The lock is tied to a dedicated connection and released when fn returns.
+func withLock(databaseURL string, fn func() error) error {
+	pool, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), LockTimeout)
+	defer cancel()
+
+	conn, err := pool.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := acquireLock(ctx, conn); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", MigrationLockKey); err != nil {
+			// The lock is released anyway when the connection is closed
+			log.Println(err.Error())
+		}
+	}()
+
+	return fn()
+}
+
+func acquireLock(ctx context.Context, conn *sql.Conn) error {
+	var lastHolder string
+	var lastLogged time.Time
+
+	for {
+		var acquired bool
+		err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", MigrationLockKey).Scan(&acquired)
+		if err != nil {
+			if ctx.Err() != nil {
+				return &LockTimeoutError{Timeout: LockTimeout, Holder: lastHolder}
+			}
+			return err
+		}
+		if acquired {
+			return nil
+		}
+
+		holder := lockHolder(ctx, conn)
+		if holder != lastHolder || time.Since(lastLogged) >= lockLogInterval {
+			log.Printf("Waiting for the migration lock (advisory lock %v) held by %v", MigrationLockKey, holder)
+			lastHolder, lastLogged = holder, time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return &LockTimeoutError{Timeout: LockTimeout, Holder: holder}
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// lockHolder describes the session holding the migration lock, for logging.
+func lockHolder(ctx context.Context, conn *sql.Conn) string {
+	var pid int
+	var applicationName, clientAddr string
+	var since time.Time
+	err := conn.QueryRowContext(
+		ctx,
+		`SELECT a.pid, a.application_name, coalesce(host(a.client_addr), 'local'), a.backend_start
+		FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.locktype = 'advisory' AND l.classid = 0 AND l.objid = $1 AND l.objsubid = 1 AND l.granted`,
+		MigrationLockKey,
+	).Scan(&pid, &applicationName, &clientAddr, &since)
+	if err != nil {
+		return "an unknown session"
+	}
+
+	return fmt.Sprintf("pid %v (application %q, client %v, connected %v)", pid, applicationName, clientAddr, since.Format(time.RFC3339))
+}
@@ -0,0 +1,198 @@
+// Package db manages the schema of the contacts database using the SQL files in db/migrations. Every function taking a
+// migrationsPath uses the migrations embedded in the binary when it is empty.
+package db
+
+import (
+	"fmt"
+	"log"
+
+	_ "github.com/mattes/migrate/driver/postgres"
+	"github.com/mattes/migrate/file"
+	"github.com/mattes/migrate/migrate"
+)
+
+// SchemaAheadError is returned when the database has migrations applied that this binary doesn't know about. This
+// usually means an older binary is being started against a database migrated by a newer one.
+type SchemaAheadError struct {
+	Version uint64
+	Latest  uint64
+}
+
+func (e *SchemaAheadError) Error() string {
+	return fmt.Sprintf(
+		"database schema version %v is ahead of the latest known migration %v, refusing to continue",
+		e.Version,
+		e.Latest,
+	)
+}
+
+// Migrations reads the migration files in migrationsPath, ordered by version.
+func Migrations(migrationsPath string) (file.MigrationFiles, error) {
+	dir, cleanup, err := resolve(migrationsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	files, err := file.ReadMigrationFiles(dir, file.FilenameRegex("sql"))
+	if err != nil {
+		return nil, err
+	}
+
+	// The migrations are read now, as the files may be removed by cleanup.
+	for i := range files {
+		for _, f := range []*file.File{files[i].UpFile, files[i].DownFile} {
+			if f == nil {
+				continue
+			}
+			if err := f.ReadContent(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// LatestVersion returns the highest migration version in migrationsPath, or 0 if there are none.
+func LatestVersion(migrationsPath string) (uint64, error) {
+	files, err := Migrations(migrationsPath)
+	if err != nil {
+		return 0, err
+	}
+
+	var latest uint64
+	for _, f := range files {
+		if f.Version > latest {
+			latest = f.Version
+		}
+	}
+
+	return latest, nil
+}
+
+// Up applies any pending up migrations. Down migrations are never run, so existing data is always preserved. A
+// *SchemaAheadError is returned, without applying anything, if the database is newer than migrationsPath.
+func Up(databaseURL, migrationsPath string) error {
+	return withMigrations(databaseURL, migrationsPath, func(dir string) error {
+		// Another replica may have applied the migrations while this one waited for the lock.
+		version, latest, err := versions(databaseURL, dir)
+		if err != nil {
+			return err
+		}
+		if version == latest {
+			log.Printf("Database schema is up to date at version %v", version)
+			return nil
+		}
+
+		log.Printf("Migrating database schema from version %v to %v", version, latest)
+		if allErrors, ok := migrate.UpSync(databaseURL, dir); !ok {
+			return fmt.Errorf("Unable to apply migrations: %v", allErrors)
+		}
+
+		return nil
+	})
+}
+
+// Reset runs every down migration and then every up migration, which DESTROYS ALL DATA. It is only intended for tests
+// and local development.
+func Reset(databaseURL, migrationsPath string) error {
+	return withMigrations(databaseURL, migrationsPath, func(dir string) error {
+		if allErrors, ok := migrate.ResetSync(databaseURL, dir); !ok {
+			return fmt.Errorf("Unable to reset database: %v", allErrors)
+		}
+
+		return nil
+	})
+}
+
+// Steps applies n up migrations when n is positive, or n down migrations when n is negative.
+func Steps(databaseURL, migrationsPath string, n int) error {
+	return withMigrations(databaseURL, migrationsPath, func(dir string) error {
+		if _, _, err := versions(databaseURL, dir); err != nil {
+			return err
+		}
+
+		return steps(databaseURL, dir, n)
+	})
+}
+
+// Goto migrates up or down until target is the most recently applied migration. A target of 0 runs every down
+// migration.
+func Goto(databaseURL, migrationsPath string, target uint64) error {
+	return withMigrations(databaseURL, migrationsPath, func(dir string) error {
+		version, _, err := versions(databaseURL, dir)
+		if err != nil {
+			return err
+		}
+
+		files, err := Migrations(dir)
+		if err != nil {
+			return err
+		}
+
+		n := 0
+		known := target == 0
+		for _, f := range files {
+			if f.Version == target {
+				known = true
+			}
+			if version < f.Version && f.Version <= target {
+				n++
+			} else if target < f.Version && f.Version <= version {
+				n--
+			}
+		}
+
+		if !known {
+			return fmt.Errorf("Unknown migration version %v", target)
+		}
+
+		return steps(databaseURL, dir, n)
+	})
+}
+
+// withMigrations resolves migrationsPath to a directory and calls fn with it while holding the migration lock.
+func withMigrations(databaseURL, migrationsPath string, fn func(dir string) error) error {
+	dir, cleanup, err := resolve(migrationsPath)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	return withLock(databaseURL, func() error {
+		return fn(dir)
+	})
+}
+
+func steps(databaseURL, dir string, n int) error {
+	if n == 0 {
+		return nil
+	}
+
+	if allErrors, ok := migrate.MigrateSync(databaseURL, dir, n); !ok {
+		return fmt.Errorf("Unable to apply migrations: %v", allErrors)
+	}
+
+	return nil
+}
+
+// versions returns the database's current schema version and the latest version in dir. A *SchemaAheadError is
+// returned if the database is newer than dir.
+func versions(databaseURL, dir string) (version uint64, latest uint64, err error) {
+	latest, err = LatestVersion(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	version, err = migrate.Version(databaseURL, dir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if version > latest {
+		return 0, 0, &SchemaAheadError{Version: version, Latest: latest}
+	}
+
+	return version, latest, nil
+}
@@ -0,0 +1,175 @@
+package db_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/circleci/cci-demo-docker/db"
+	"github.com/circleci/cci-demo-docker/service"
+	"github.com/circleci/cci-demo-docker/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpPreservesData(t *testing.T) {
+	// SETUP:
+	database := test.SetupDB(t)
+	defer database.Close()
+	cfg := test.Config(t)
+
+	ctx := service.WithAccount(context.Background(), service.DefaultAccountID)
+	_, err := database.AddContact(ctx, service.Contact{Email: "alice@example.xyz", Name: "Alice Zulu"})
+	require.NoError(t, err)
+
+	// TEST: Running the startup migrations again.
+	err = db.Up(cfg.DB.OwnerURL(), cfg.DB.Migrations)
+
+	// VERIFY: Existing contacts are still there.
+	require.NoError(t, err)
+	contact, err := database.GetContactByEmail(ctx, "alice@example.xyz")
+	require.NoError(t, err)
+	assert.NotNil(t, contact)
+}
+
+func Test_UpRefusesSchemaAhead(t *testing.T) {
+	// SETUP: A database migrated by a newer binary.
+	database := test.SetupDB(t)
+	defer database.Close()
+	cfg := test.Config(t)
+	owner := test.OwnerDB(t)
+	defer owner.Close()
+
+	_, err := owner.Exec("INSERT INTO schema_migrations (version) VALUES (999999)")
+	require.NoError(t, err)
+	defer owner.Exec("DELETE FROM schema_migrations WHERE version = 999999")
+
+	// TEST:
+	err = db.Up(cfg.DB.OwnerURL(), cfg.DB.Migrations)
+
+	// VERIFY: Startup is refused.
+	require.Error(t, err)
+	require.IsType(t, &db.SchemaAheadError{}, err)
+	assert.Equal(t, uint64(999999), err.(*db.SchemaAheadError).Version)
+}
+
+func Test_GotoAndStatus(t *testing.T) {
+	// SETUP:
+	database := test.SetupDB(t)
+	defer database.Close()
+	cfg := test.Config(t)
+
+	// TEST: Rolling back everything.
+	err := db.Goto(cfg.DB.OwnerURL(), cfg.DB.Migrations, 0)
+	require.NoError(t, err)
+
+	// VERIFY: Every migration is pending.
+	statuses, err := db.Status(cfg.DB.OwnerURL(), cfg.DB.Migrations)
+	require.NoError(t, err)
+	require.NotEmpty(t, statuses)
+	for _, status := range statuses {
+		assert.False(t, status.Applied, "Version %v should be pending", status.Version)
+	}
+
+	// TEST: Migrating back to the latest version.
+	latest, err := db.LatestVersion(cfg.DB.Migrations)
+	require.NoError(t, err)
+	err = db.Goto(cfg.DB.OwnerURL(), cfg.DB.Migrations, latest)
+	require.NoError(t, err)
+
+	// VERIFY: Every migration is applied.
+	statuses, err = db.Status(cfg.DB.OwnerURL(), cfg.DB.Migrations)
+	require.NoError(t, err)
+	for _, status := range statuses {
+		assert.True(t, status.Applied, "Version %v should be applied", status.Version)
+	}
+}
+
+func Test_Create(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrations")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "001_contacts.up.sql"), nil, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "001_contacts.down.sql"), nil, 0644))
+
+	upFile, downFile, err := db.Create(dir, "add_phone")
+
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "002_add_phone.up.sql"), upFile)
+	assert.Equal(t, filepath.Join(dir, "002_add_phone.down.sql"), downFile)
+
+	_, _, err = db.Create(dir, "Not Valid")
+	assert.Error(t, err)
+}
+
+func Test_ListEmbedded(t *testing.T) {
+	files, err := db.List("")
+
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	assert.Equal(t, "001_contacts.down.sql", files[0].Name)
+	assert.Len(t, files[0].SHA256, 64)
+}
+
+func Test_UpWaitsForMigrationLock(t *testing.T) {
+	// SETUP: Another replica holding the migration lock.
+	database := test.SetupDB(t)
+	defer database.Close()
+	cfg := test.Config(t)
+
+	conn, err := database.DB.Conn(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.ExecContext(context.Background(), "SELECT pg_advisory_lock($1)", db.MigrationLockKey)
+	require.NoError(t, err)
+
+	defer func(timeout time.Duration) { db.LockTimeout = timeout }(db.LockTimeout)
+	db.LockTimeout = time.Second
+
+	// TEST:
+	err = db.Up(cfg.DB.OwnerURL(), cfg.DB.Migrations)
+
+	// VERIFY: The migration gave up waiting.
+	require.Error(t, err)
+	assert.IsType(t, &db.LockTimeoutError{}, err)
+
+	// TEST: Once the lock is released.
+	_, err = conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", db.MigrationLockKey)
+	require.NoError(t, err)
+	err = db.Up(cfg.DB.OwnerURL(), cfg.DB.Migrations)
+
+	// VERIFY: The already applied migrations are skipped.
+	require.NoError(t, err)
+}
+
+func Test_CheckSchema(t *testing.T) {
+	// SETUP:
+	database := test.SetupDB(t)
+	defer database.Close()
+	cfg := test.Config(t)
+
+	// TEST: A freshly migrated database.
+	drift, err := db.CheckSchema(cfg.DB.OwnerURL(), cfg.DB.Migrations)
+
+	// VERIFY: No drift.
+	require.NoError(t, err)
+	assert.True(t, drift.Empty(), drift.String())
+
+	// TEST: After a hand-applied hotfix.
+	owner := test.OwnerDB(t)
+	defer owner.Close()
+	_, err = owner.Exec("CREATE INDEX contacts_name_idx ON contacts (name)")
+	require.NoError(t, err)
+	drift, err = db.CheckSchema(cfg.DB.OwnerURL(), cfg.DB.Migrations)
+
+	// VERIFY: The extra index is reported.
+	require.NoError(t, err)
+	require.False(t, drift.Empty())
+	assert.Empty(t, drift.Missing)
+	require.Len(t, drift.Unexpected, 1)
+	assert.Contains(t, drift.Unexpected[0], "contacts_name_idx")
+}
@@ -0,0 +1,9 @@
+ALTER TABLE api_keys DROP COLUMN account_id;
+
+-- Contacts of other accounts are deleted, as their emails may clash with the default account's.
+DELETE FROM contacts WHERE account_id <> (SELECT id FROM accounts WHERE name = 'default');
+ALTER TABLE contacts DROP CONSTRAINT contacts_account_id_email_key;
+ALTER TABLE contacts ADD CONSTRAINT contacts_email_key UNIQUE (email);
+ALTER TABLE contacts DROP COLUMN account_id;
+
+DROP TABLE accounts;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS accounts (
+    id SERIAL PRIMARY KEY,
+    name varchar(255) UNIQUE NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- The default account has the id of service.DefaultAccountID. Existing contacts and API keys are moved into it.
+INSERT INTO accounts (id, name) VALUES (1, 'default');
+SELECT setval(pg_get_serial_sequence('accounts', 'id'), 1);
+
+ALTER TABLE contacts ADD COLUMN account_id integer REFERENCES accounts (id);
+UPDATE contacts SET account_id = (SELECT id FROM accounts WHERE name = 'default');
+ALTER TABLE contacts ALTER COLUMN account_id SET NOT NULL;
+ALTER TABLE contacts DROP CONSTRAINT contacts_email_key;
+ALTER TABLE contacts ADD CONSTRAINT contacts_account_id_email_key UNIQUE (account_id, email);
+
+ALTER TABLE api_keys ADD COLUMN account_id integer REFERENCES accounts (id);
+UPDATE api_keys SET account_id = (SELECT id FROM accounts WHERE name = 'default');
+ALTER TABLE api_keys ALTER COLUMN account_id SET NOT NULL;
@@ -0,0 +1,6 @@
+DROP POLICY contacts_account_isolation ON contacts;
+ALTER TABLE contacts DISABLE ROW LEVEL SECURITY;
+
+-- The role is left in place, as roles are shared by every database of the cluster.
+REVOKE ALL ON contacts, accounts, api_keys, rate_limit_buckets FROM contacts_app;
+REVOKE ALL ON SEQUENCE contacts_id_seq, accounts_id_seq, api_keys_id_seq FROM contacts_app;
@@ -0,0 +1,18 @@
+-- The service connects as a member of contacts_app, which doesn't own the tables, so that row-level security applies
+-- to it. Tables added by later migrations must be granted to it too.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'contacts_app') THEN
+        CREATE ROLE contacts_app NOLOGIN;
+    END IF;
+END
+$$;
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON contacts, accounts, api_keys, rate_limit_buckets TO contacts_app;
+GRANT USAGE ON SEQUENCE contacts_id_seq, accounts_id_seq, api_keys_id_seq TO contacts_app;
+
+-- Rows are only visible to, and can only be written by, transactions scoped to their account with
+-- `SET LOCAL contacts.account_id`, see Database.begin. Without it, no rows are visible.
+ALTER TABLE contacts ENABLE ROW LEVEL SECURITY;
+CREATE POLICY contacts_account_isolation ON contacts
+    USING (account_id = NULLIF(current_setting('contacts.account_id', true), '')::integer);
@@ -0,0 +1,6 @@
+DROP POLICY api_keys_lookup ON api_keys;
+DROP POLICY api_keys_account_isolation ON api_keys;
+ALTER TABLE api_keys NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE api_keys DISABLE ROW LEVEL SECURITY;
+
+ALTER TABLE contacts NO FORCE ROW LEVEL SECURITY;
@@ -0,0 +1,12 @@
+-- Row-level security is forced, so that it applies to the role owning the tables too, and extended to API keys.
+ALTER TABLE contacts FORCE ROW LEVEL SECURITY;
+
+ALTER TABLE api_keys ENABLE ROW LEVEL SECURITY;
+ALTER TABLE api_keys FORCE ROW LEVEL SECURITY;
+CREATE POLICY api_keys_account_isolation ON api_keys
+    USING (account_id = NULLIF(current_setting('contacts.account_id', true), '')::integer);
+
+-- A key is found by its prefix to authenticate the caller, before its account is known. Only the key whose prefix is
+-- set with `SET LOCAL contacts.api_key_prefix` is visible, and only to read it, see Transaction.findAPIKey.
+CREATE POLICY api_keys_lookup ON api_keys FOR SELECT
+    USING (prefix = NULLIF(current_setting('contacts.api_key_prefix', true), ''));
@@ -0,0 +1,237 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/mattes/migrate/migrate"
+)
+
+// Drift describes how the tables owned by the service differ from the schema produced by the migrations. Each entry is
+// a line describing a table, column or index, in the form produced by describeSchema.
+type Drift struct {
+	// Version is the migration version the database was compared against.
+	Version uint64
+	// Missing lists what the migrations create but the database lacks.
+	Missing []string
+	// Unexpected lists what the database has but the migrations don't create.
+	Unexpected []string
+}
+
+// Empty reports whether the database matches the migrations.
+func (d *Drift) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Unexpected) == 0
+}
+
+// String renders the drift as a diff from the expected schema to the actual one.
+func (d *Drift) String() string {
+	if d.Empty() {
+		return fmt.Sprintf("Schema matches migrations up to version %v\n", d.Version)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Schema differs from migrations up to version %v\n", d.Version)
+	fmt.Fprintln(&b, "--- expected (migrations)")
+	fmt.Fprintln(&b, "+++ actual (database)")
+	for _, line := range d.Missing {
+		fmt.Fprintln(&b, "- "+line)
+	}
+	for _, line := range d.Unexpected {
+		fmt.Fprintln(&b, "+ "+line)
+	}
+
+	return b.String()
+}
+
+// CheckSchema compares the tables in the database with the schema the migrations in migrationsPath produce at the
+// database's current version. The expected schema is built by applying the migrations to a scratch schema inside a
+// transaction that is always rolled back, so the check never modifies the database.
+func CheckSchema(databaseURL, migrationsPath string) (*Drift, error) {
+	files, err := Migrations(migrationsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := migrate.Version(databaseURL, "")
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	tx, err := conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var actualSchema string
+	if err := tx.QueryRow("SELECT current_schema()").Scan(&actualSchema); err != nil {
+		return nil, err
+	}
+
+	scratchSchema := fmt.Sprintf("contacts_schema_check_%d", rand.Int31())
+	if _, err := tx.Exec("CREATE SCHEMA " + scratchSchema); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("SET LOCAL search_path TO " + scratchSchema); err != nil {
+		return nil, err
+	}
+
+	latest := uint64(0)
+	sort.Sort(files)
+	for _, f := range files {
+		if f.Version > version || f.UpFile == nil {
+			continue
+		}
+		if _, err := tx.Exec(string(f.UpFile.Content)); err != nil {
+			return nil, fmt.Errorf("Unable to apply %v to scratch schema: %v", f.UpFile.FileName, err)
+		}
+		latest = f.Version
+	}
+	if latest != version {
+		return nil, &SchemaAheadError{Version: version, Latest: latest}
+	}
+
+	expected, tables, err := describeSchema(tx, scratchSchema, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	actual, _, err := describeSchema(tx, actualSchema, tables)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffSchema(version, expected, actual), nil
+}
+
+// describeSchema describes the tables, columns and indexes of schema as sorted lines, with the schema name stripped so
+// that different schemas can be compared. Only the given tables are described, or every table if tables is nil. The
+// names of the described tables are also returned.
+func describeSchema(tx *sql.Tx, schema string, tables []string) ([]string, []string, error) {
+	owned := make(map[string]bool)
+	for _, table := range tables {
+		owned[table] = true
+	}
+	include := func(table string) bool {
+		return tables == nil || owned[table]
+	}
+
+	var lines, described []string
+	strip := func(s string) string {
+		return strings.Replace(s, schema+".", "", -1)
+	}
+
+	rows, err := tx.Query(
+		"SELECT table_name FROM information_schema.tables WHERE table_schema = $1 AND table_type = 'BASE TABLE' AND table_name <> 'schema_migrations'",
+		schema,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		if include(table) {
+			lines = append(lines, "table "+table)
+			described = append(described, table)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = tx.Query(
+		`SELECT table_name, column_name, data_type, character_maximum_length, is_nullable, column_default
+		FROM information_schema.columns WHERE table_schema = $1`,
+		schema,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var table, column, dataType, nullable string
+		var maxLength sql.NullInt64
+		var columnDefault sql.NullString
+		if err := rows.Scan(&table, &column, &dataType, &maxLength, &nullable, &columnDefault); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		if !include(table) || table == "schema_migrations" {
+			continue
+		}
+
+		line := fmt.Sprintf("column %v.%v %v", table, column, dataType)
+		if maxLength.Valid {
+			line += fmt.Sprintf("(%v)", maxLength.Int64)
+		}
+		if nullable == "NO" {
+			line += " NOT NULL"
+		}
+		if columnDefault.Valid {
+			line += " DEFAULT " + strip(columnDefault.String)
+		}
+		lines = append(lines, line)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = tx.Query("SELECT tablename, indexdef FROM pg_indexes WHERE schemaname = $1", schema)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var table, indexDef string
+		if err := rows.Scan(&table, &indexDef); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		if include(table) && table != "schema_migrations" {
+			lines = append(lines, "index "+strip(indexDef))
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	sort.Strings(lines)
+	return lines, described, nil
+}
+
+func diffSchema(version uint64, expected, actual []string) *Drift {
+	drift := &Drift{Version: version}
+
+	inActual := make(map[string]bool)
+	for _, line := range actual {
+		inActual[line] = true
+	}
+	inExpected := make(map[string]bool)
+	for _, line := range expected {
+		inExpected[line] = true
+		if !inActual[line] {
+			drift.Missing = append(drift.Missing, line)
+		}
+	}
+	for _, line := range actual {
+		if !inExpected[line] {
+			drift.Unexpected = append(drift.Unexpected, line)
+		}
+	}
+
+	return drift
+}
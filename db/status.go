@@ -0,0 +1,86 @@
+package db
+
+import (
+	"database/sql"
+	"sort"
+)
+
+// VersionStatus describes one migration version, either known to this binary, applied to the database, or both.
+type VersionStatus struct {
+	Version uint64
+	// Name is empty when the version was applied by a migration this binary doesn't know about.
+	Name    string
+	Applied bool
+}
+
+// Known reports whether this binary has the migration files for the version.
+func (s VersionStatus) Known() bool {
+	return s.Name != ""
+}
+
+// Status lists every migration version, ordered by version, and whether it has been applied to the database.
+func Status(databaseURL, migrationsPath string) ([]VersionStatus, error) {
+	files, err := Migrations(migrationsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedVersions(databaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[uint64]*VersionStatus)
+	for _, f := range files {
+		statuses[f.Version] = &VersionStatus{Version: f.Version, Name: f.UpFile.Name}
+	}
+	for _, version := range applied {
+		status, ok := statuses[version]
+		if !ok {
+			status = &VersionStatus{Version: version}
+			statuses[version] = status
+		}
+		status.Applied = true
+	}
+
+	result := make([]VersionStatus, 0, len(statuses))
+	for _, status := range statuses {
+		result = append(result, *status)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+
+	return result, nil
+}
+
+// appliedVersions reads the versions recorded by the migrate postgres driver. The table may not exist yet if the
+// database has never been migrated.
+func appliedVersions(databaseURL string) ([]uint64, error) {
+	conn, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var exists bool
+	err = conn.QueryRow("SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := conn.Query("SELECT version FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []uint64
+	for rows.Next() {
+		var version uint64
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
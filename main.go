@@ -1,42 +1,430 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
+	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/circleci/cci-demo-docker/config"
+	"github.com/circleci/cci-demo-docker/db"
+	"github.com/circleci/cci-demo-docker/metrics"
 	"github.com/circleci/cci-demo-docker/service"
-	_ "github.com/mattes/migrate/driver/postgres"
-	"github.com/mattes/migrate/migrate"
+	"github.com/circleci/cci-demo-docker/tracing"
 )
 
+// Exit codes returned by every command, so that scripts can tell failures apart.
+const (
+	exitOK      = 0 // The command succeeded.
+	exitError   = 1 // The command failed, see stderr for details.
+	exitUsage   = 2 // The command line was invalid.
+	exitPending = 3 // `migrate status` found migrations that haven't been applied.
+)
+
+// command is a subcommand of the contacts binary.
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "serve [FLAGS]         Run the HTTP service (default), see `contacts serve -h` for settings", runServe},
+		{"migrate", "migrate SUBCOMMAND    Inspect and apply database migrations, see `contacts migrate help`", runMigrate},
+		{"schema", "schema check          Compare the database schema with the migrations, exits 1 on drift", runSchema},
+		{"seed", "seed [-upsert] FILE   Insert the records in a YAML or JSON fixtures file", runSeed},
+		{"account", "account SUBCOMMAND    Create and list accounts, see `contacts account help`", runAccount},
+		{"apikey", "apikey SUBCOMMAND     Create, list and revoke API keys, see `contacts apikey help`", runAPIKey},
+		{"config", "config print          Print the effective configuration, with secrets redacted", runConfig},
+		{"help", "help                  Show this help", runHelp},
+	}
+}
+
 func main() {
-	db := SetupDB()
-	server := service.NewServer(db)
-	http.HandleFunc("/", server.ServeHTTP)
-	http.ListenAndServe(":8080", nil)
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	defer closeLogFile()
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runServe(args)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "contacts: unknown command %q\n\n", args[0])
+	printUsage(os.Stderr)
+	return exitUsage
+}
+
+func runHelp(args []string) int {
+	printUsage(os.Stdout)
+	return exitOK
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: contacts [COMMAND] [FLAGS] [ARGS...]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintln(w, "  "+cmd.usage)
+	}
+}
+
+// fail prints err and returns the exit code for a failed command.
+func fail(err error) int {
+	fmt.Fprintf(os.Stderr, "contacts: %v\n", err)
+	return exitError
+}
+
+// usageError prints a usage message and returns the exit code for an invalid command line.
+func usageError(format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "contacts: "+format+"\n", args...)
+	return exitUsage
+}
+
+// loadConfig parses the flags in args for the named command, then loads the configuration, and applies the settings
+// shared by every command. Commands can define their own flags with defineFlags. The positional arguments that follow
+// the flags are returned. If the configuration can't be loaded, the returned Config is nil and the int is the exit
+// code to use.
+func loadConfig(name string, args []string, defineFlags ...func(fs *flag.FlagSet)) (*config.Config, []string, int) {
+	fs := flag.NewFlagSet("contacts "+name, flag.ContinueOnError)
+	loader := config.BindFlags(fs)
+	for _, define := range defineFlags {
+		define(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil, nil, exitOK
+		}
+		return nil, nil, exitUsage
+	}
+
+	cfg, err := loader.Load()
+	if err != nil {
+		return nil, nil, fail(err)
+	}
+
+	if err := setupLogging(cfg.Log); err != nil {
+		return nil, nil, fail(err)
+	}
+
+	db.LockTimeout = time.Duration(cfg.DB.MigrationLockTimeout)
+
+	return cfg, fs.Args(), exitOK
+}
+
+// logFile is the file logs are appended to, if they aren't written to stderr or stdout.
+var logFile *os.File
+
+// closeLogFile closes the log file, once the command has finished logging.
+func closeLogFile() {
+	if logFile != nil {
+		logFile.Close()
+		logFile = nil
+	}
+}
+
+// setupLogging replaces the default logger, which is also used by the log package, with one configured by cfg.
+func setupLogging(cfg config.Log) error {
+	var out io.Writer
+	switch cfg.Output {
+	case "stderr":
+		out = os.Stderr
+	case "stdout":
+		out = os.Stdout
+	default:
+		file, err := os.OpenFile(cfg.Output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return fmt.Errorf("Unable to open log output: %v", err)
+		}
+		closeLogFile()
+		logFile = file
+		out = file
+	}
+
+	level, err := cfg.SlogLevel()
+	if err != nil {
+		return err
+	}
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler = slog.NewJSONHandler(out, options)
+	if cfg.Format == "text" {
+		handler = slog.NewTextHandler(out, options)
+	}
+	slog.SetDefault(slog.New(service.NewLogHandler(handler)))
+
+	return nil
+}
+
+// ===== SERVE =========================================================================================================
+
+func runServe(args []string) int {
+	cfg, args, code := loadConfig("serve", args)
+	if cfg == nil {
+		return code
+	}
+	if len(args) != 0 {
+		return usageError("serve takes no arguments")
+	}
+
+	tracer, err := setupTracing(cfg.Tracing)
+	if err != nil {
+		return fail(err)
+	}
+	if tracer != nil {
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), tracing.BatchTimeout)
+			defer cancel()
+			if err := tracer.Shutdown(ctx); err != nil {
+				slog.Error("Unable to export the remaining spans", "error", err.Error())
+			}
+		}()
+	}
+
+	database := SetupDB(cfg)
+	if err := checkRowLevelSecurity(database, cfg.DB.AllowRLSBypass); err != nil {
+		database.Close()
+		return fail(err)
+	}
+	server := service.NewServer(database, serverOptions(cfg, database)...)
+	checkSchemaAtStartup(cfg, server)
+
+	httpServer := &http.Server{
+		Addr:         cfg.ListenAddr,
+		Handler:      server,
+		ReadTimeout:  time.Duration(cfg.HTTP.ReadTimeout),
+		WriteTimeout: time.Duration(cfg.HTTP.WriteTimeout),
+		IdleTimeout:  time.Duration(cfg.HTTP.IdleTimeout),
+	}
+
+	if cfg.TLS.Enabled() {
+		tlsConfig, reloader, err := serverTLSConfig(cfg.TLS, server.Logger())
+		if err != nil {
+			return fail(err)
+		}
+		defer reloader.Close()
+		httpServer.TLSConfig = tlsConfig
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	if err := serve(cfg, httpServer, server, database, signals); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+// serve runs httpServer until a signal is received, then shuts down gracefully: readiness fails first so that load
+// balancers stop sending traffic, then new connections are refused while in-flight requests finish, and finally the
+// database is closed.
+func serve(cfg *config.Config, httpServer *http.Server, server *service.Server, database *service.Database, signals <-chan os.Signal) error {
+	serveErrors := make(chan error, 1)
+	go func() {
+		if httpServer.TLSConfig != nil {
+			// The certificate is provided by TLSConfig.GetCertificate
+			serveErrors <- httpServer.ListenAndServeTLS("", "")
+		} else {
+			serveErrors <- httpServer.ListenAndServe()
+		}
+	}()
+	slog.Info("Listening", "addr", httpServer.Addr)
+
+	select {
+	case err := <-serveErrors:
+		database.Close()
+		return err
+	case sig := <-signals:
+		slog.Info("Shutting down", "signal", sig.String())
+	}
+
+	server.Readiness.Fail("shutdown", "shutting down")
+	time.Sleep(time.Duration(cfg.HTTP.DrainDelay))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.HTTP.ShutdownTimeout))
+	defer cancel()
+
+	shutdownErr := httpServer.Shutdown(ctx)
+	if shutdownErr != nil {
+		shutdownErr = fmt.Errorf("In-flight requests didn't finish within %v: %v", cfg.HTTP.ShutdownTimeout, shutdownErr)
+	}
+
+	if err := database.Close(); err != nil && shutdownErr == nil {
+		return err
+	}
+
+	if shutdownErr == nil {
+		slog.Info("Shutdown complete")
+	}
+	return shutdownErr
 }
 
-func SetupDB() *service.Database {
-	databaseUrl := os.Getenv("CONTACTS_DB_URL")
-	if databaseUrl == "" {
-		panic("CONTACTS_DB_URL must be set!")
+// serverOptions configures the Server from cfg, which has already been validated.
+func serverOptions(cfg *config.Config, database *service.Database) []service.ServerOption {
+	options := []service.ServerOption{service.WithMaxBodySize(int64(cfg.HTTP.MaxBodySize))}
+
+	if deprecation, _ := cfg.API.LegacyDeprecationTime(); !deprecation.IsZero() {
+		options = append(options, service.WithLegacyDeprecation(deprecation))
+	}
+	if sunset, _ := cfg.API.LegacySunsetTime(); !sunset.IsZero() {
+		options = append(options, service.WithLegacySunset(sunset))
+	}
+	if cfg.API.ValidateRequests {
+		options = append(options, service.WithRequestValidation())
 	}
 
-	sqlFiles := "./db/migrations"
-	if sqlFilesEnv := os.Getenv("CONTACTS_DB_MIGRATIONS"); sqlFilesEnv != "" {
-		sqlFiles = sqlFilesEnv
+	// Failed authentications are limited even when requests aren't, keeping their buckets in memory
+	var store service.RateLimitStore = service.NewMemoryRateLimitStore()
+	if cfg.RateLimit.Store == "postgres" {
+		store = service.NewPostgresRateLimitStore(database)
 	}
-	allErrors, ok := migrate.ResetSync(databaseUrl, sqlFiles)
-	if !ok {
-		panic(fmt.Sprintf("%+v", allErrors))
+	authenticationLimit, _ := config.ParseLimit(cfg.RateLimit.Authentication)
+	options = append(options, service.WithAuthenticationLimit(store, serviceLimit(authenticationLimit)))
+
+	if cfg.RateLimit.Store != "none" {
+		defaultLimit, routeLimits, _ := cfg.RateLimit.Limits()
+		limiter := &service.RateLimiter{
+			Store:   store,
+			Default: serviceLimit(defaultLimit),
+			Routes:  map[string]service.Limit{},
+		}
+		for route, limit := range routeLimits {
+			limiter.Routes[route] = serviceLimit(limit)
+		}
+		options = append(options, service.WithRateLimiter(limiter))
 	}
 
-	db, err := sql.Open("postgres", databaseUrl)
+	if cfg.CORS.Enabled() {
+		options = append(options, service.WithCORS(&service.CORS{
+			AllowedOrigins:   cfg.CORS.AllowedOrigins,
+			AllowedMethods:   cfg.CORS.AllowedMethods,
+			AllowedHeaders:   cfg.CORS.AllowedHeaders,
+			AllowCredentials: cfg.CORS.AllowCredentials,
+			MaxAge:           time.Duration(cfg.CORS.MaxAge),
+		}))
+	}
+
+	if cfg.Auth.APIKeys {
+		options = append(options, service.WithAuthentication(&service.APIKeyAuthenticator{DB: database}))
+	}
+	if jwt := cfg.Auth.JWT; jwt.Enabled() {
+		keys := service.NewJWKSURL(jwt.JWKSURL, time.Duration(jwt.JWKSRefreshInterval))
+		if jwt.JWKSFile != "" {
+			keys = service.NewJWKSFile(jwt.JWKSFile, time.Duration(jwt.JWKSRefreshInterval))
+		}
+		options = append(options, service.WithAuthentication(&service.JWTAuthenticator{
+			Issuer:       jwt.Issuer,
+			Audience:     jwt.Audience,
+			Keys:         keys,
+			ClockSkew:    time.Duration(jwt.ClockSkew),
+			AccountClaim: jwt.AccountClaim,
+			DB:           database,
+		}))
+	}
+
+	return options
+}
+
+func serviceLimit(limit config.Limit) service.Limit {
+	return service.Limit{Rate: limit.Rate, Period: limit.Period, Burst: limit.Burst}
+}
+
+// setupTracing sets the default Tracer to export spans as configured by cfg. Nil is returned if tracing is disabled.
+func setupTracing(cfg config.Tracing) (*tracing.Tracer, error) {
+	var exporter tracing.Exporter
+	switch cfg.Exporter {
+	case "none":
+		return nil, nil
+	case "stdout":
+		exporter = tracing.NewWriterExporter(cfg.ServiceName, os.Stdout)
+	case "file":
+		fileExporter, err := tracing.NewFileExporter(cfg.ServiceName, cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		exporter = fileExporter
+	case "otlp":
+		exporter = tracing.NewOTLPExporter(cfg.ServiceName, cfg.Endpoint, nil)
+	}
+
+	tracer := tracing.NewTracer(exporter)
+	tracing.SetDefault(tracer)
+	return tracer, nil
+}
+
+// serverTLSConfig builds the TLS configuration for serving HTTPS, reloading the certificate when its files change.
+func serverTLSConfig(cfg config.TLS, logger *slog.Logger) (*tls.Config, *service.CertReloader, error) {
+	reloader, err := service.NewCertReloader(cfg.CertFile, cfg.KeyFile, time.Duration(cfg.ReloadInterval), logger)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to load TLS certificate: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if cfg.ClientAuth != "none" {
+		pool, err := service.LoadCertPool(cfg.ClientCAFile)
+		if err != nil {
+			reloader.Close()
+			return nil, nil, fmt.Errorf("Unable to load client CA bundle: %v", err)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if cfg.ClientAuth == "require" {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return tlsConfig, reloader, nil
+}
+
+// SetupDB applies any pending migrations and opens a connection to the database. Existing data is never dropped.
+func SetupDB(cfg *config.Config) *service.Database {
+	if err := cfg.RequireDB(); err != nil {
+		panic(err.Error())
+	}
+
+	if err := db.Up(cfg.DB.OwnerURL(), cfg.DB.Migrations); err != nil {
+		panic(fmt.Sprintf("Unable to migrate database: %v", err))
+	}
+
+	sqlDB, err := sql.Open("postgres", cfg.DB.URL)
 	if err != nil {
 		panic(fmt.Sprintf("Unable to open DB connection: %+v", err))
 	}
+	cfg.DB.ConfigurePool(sqlDB)
+	metrics.MustRegister(metrics.NewDBStatsCollector(sqlDB, "contacts_db"))
+
+	latency := service.NewLatencyHistogram()
+	metrics.MustRegister(latency)
+	database := &service.Database{DB: sqlDB, Hooks: []service.Hook{latency}}
+	if cfg.Log.SlowQueryThreshold > 0 {
+		database.Hooks = append(database.Hooks, &service.SlowQueryLogger{
+			Threshold: time.Duration(cfg.Log.SlowQueryThreshold),
+		})
+	}
 
-	return &service.Database{db}
+	return database
 }
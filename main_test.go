@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/circleci/cci-demo-docker/config"
+	"github.com/circleci/cci-demo-docker/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ServeDrainsOnSignal(t *testing.T) {
+	// SETUP: A server with a slow in-flight request. The database is never connected to.
+	cfg := config.Default()
+	cfg.HTTP.DrainDelay = config.Duration(50 * time.Millisecond)
+	cfg.HTTP.ShutdownTimeout = config.Duration(5 * time.Second)
+
+	sqlDB, err := sql.Open("postgres", "postgres://localhost/unused")
+	require.NoError(t, err)
+	database := &service.Database{DB: sqlDB}
+	server := service.NewServer(database)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	started := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.Handle("/", server)
+	httpServer := &http.Server{Addr: addr, Handler: mux}
+
+	signals := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() { done <- serve(cfg, httpServer, server, database, signals) }()
+
+	var response *http.Response
+	requestDone := make(chan error, 1)
+	require.True(t, waitFor(func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}), "Server didn't start listening")
+	go func() {
+		var err error
+		response, err = http.Get("http://" + addr + "/slow")
+		requestDone <- err
+	}()
+	<-started
+
+	// TEST:
+	signals <- syscall.SIGTERM
+
+	// VERIFY: Readiness fails first, then the in-flight request completes, and serve returns cleanly.
+	assert.True(t, waitFor(func() bool {
+		ready, _ := server.Readiness.Ready()
+		return !ready
+	}), "Readiness should fail during shutdown")
+
+	require.NoError(t, <-requestDone)
+	assert.Equal(t, http.StatusNoContent, response.StatusCode)
+	assert.NoError(t, <-done)
+}
+
+// waitFor polls condition for up to a second, returning whether it became true.
+func waitFor(condition func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,253 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets are latency buckets in seconds, suitable for HTTP requests and database transactions.
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// vec holds one child metric per combination of label values.
+type vec struct {
+	name       string
+	help       string
+	labelNames []string
+
+	mu       sync.Mutex
+	children map[string]interface{}
+	labels   map[string][]Label
+}
+
+func newVec(name string, help string, labelNames []string) vec {
+	return vec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		children:   make(map[string]interface{}),
+		labels:     make(map[string][]Label),
+	}
+}
+
+// child returns the metric for the given label values, creating it with newChild the first time.
+func (v *vec) child(labelValues []string, newChild func() interface{}) interface{} {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("Metric %v expects %v label values, got %v", v.name, len(v.labelNames), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	c, ok := v.children[key]
+	if !ok {
+		c = newChild()
+		v.children[key] = c
+
+		labels := make([]Label, len(labelValues))
+		for i, value := range labelValues {
+			labels[i] = Label{Name: v.labelNames[i], Value: value}
+		}
+		v.labels[key] = labels
+	}
+
+	return c
+}
+
+// each calls fn for every child, ordered by label values.
+func (v *vec) each(fn func(labels []Label, c interface{})) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	labels := make([][]Label, len(keys))
+	children := make([]interface{}, len(keys))
+	for i, key := range keys {
+		labels[i], children[i] = v.labels[key], v.children[key]
+	}
+	v.mu.Unlock()
+
+	for i := range keys {
+		fn(labels[i], children[i])
+	}
+}
+
+// atomicFloat is a float64 that can be added to concurrently.
+type atomicFloat struct {
+	bits uint64
+}
+
+func (f *atomicFloat) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&f.bits, old, updated) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+// ===== COUNTER =======================================================================================================
+
+// Counter is a value that only goes up, like a number of requests.
+type Counter struct {
+	value atomicFloat
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() {
+	c.value.add(1)
+}
+
+// Add adds delta, which must not be negative, to the counter.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("Counters can't decrease")
+	}
+	c.value.add(delta)
+}
+
+// CounterVec is a Counter for each combination of label values.
+type CounterVec struct {
+	vec
+}
+
+// NewCounterVec creates a CounterVec. It has to be registered to be exported.
+func NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{newVec(name, help, labelNames)}
+}
+
+// With returns the Counter for the given label values, in the order of the label names.
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	return v.child(labelValues, func() interface{} { return &Counter{} }).(*Counter)
+}
+
+func (v *CounterVec) Collect() []Family {
+	family := Family{Name: v.name, Help: v.help, Type: TypeCounter}
+	v.each(func(labels []Label, c interface{}) {
+		family.Samples = append(family.Samples, Sample{Labels: labels, Value: c.(*Counter).value.load()})
+	})
+
+	return []Family{family}
+}
+
+// ===== GAUGE =========================================================================================================
+
+// GaugeFunc is a gauge whose value is read when metrics are collected, e.g. the size of a pool.
+type GaugeFunc struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+// NewGaugeFunc creates a GaugeFunc. It has to be registered to be exported.
+func NewGaugeFunc(name string, help string, value func() float64) *GaugeFunc {
+	return &GaugeFunc{name: name, help: help, value: value}
+}
+
+func (g *GaugeFunc) Collect() []Family {
+	return []Family{{
+		Name:    g.name,
+		Help:    g.help,
+		Type:    TypeGauge,
+		Samples: []Sample{{Value: g.value()}},
+	}}
+}
+
+// ===== HISTOGRAM =====================================================================================================
+
+// Histogram counts observations, like latencies, into buckets.
+type Histogram struct {
+	upperBounds []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe adds a single observation to the histogram.
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.count++
+	h.sum += value
+	for i, upperBound := range h.upperBounds {
+		if value <= upperBound {
+			h.counts[i]++
+		}
+	}
+}
+
+// ObserveDuration adds a duration to the histogram, in seconds.
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+// HistogramVec is a Histogram for each combination of label values.
+type HistogramVec struct {
+	vec
+	upperBounds []float64
+}
+
+// NewHistogramVec creates a HistogramVec with the given bucket upper bounds, or DefaultBuckets. It has to be
+// registered to be exported.
+func NewHistogramVec(name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	upperBounds := append([]float64(nil), buckets...)
+	sort.Float64s(upperBounds)
+
+	return &HistogramVec{newVec(name, help, labelNames), upperBounds}
+}
+
+// With returns the Histogram for the given label values, in the order of the label names.
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	return v.child(labelValues, func() interface{} {
+		return &Histogram{upperBounds: v.upperBounds, counts: make([]uint64, len(v.upperBounds))}
+	}).(*Histogram)
+}
+
+func (v *HistogramVec) Collect() []Family {
+	family := Family{Name: v.name, Help: v.help, Type: TypeHistogram}
+	v.each(func(labels []Label, c interface{}) {
+		h := c.(*Histogram)
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		for i, upperBound := range h.upperBounds {
+			family.Samples = append(family.Samples, Sample{
+				Suffix: "_bucket",
+				Labels: withLabel(labels, "le", formatValue(upperBound)),
+				Value:  float64(h.counts[i]),
+			})
+		}
+		family.Samples = append(family.Samples,
+			Sample{Suffix: "_bucket", Labels: withLabel(labels, "le", "+Inf"), Value: float64(h.count)},
+			Sample{Suffix: "_sum", Labels: labels, Value: h.sum},
+			Sample{Suffix: "_count", Labels: labels, Value: float64(h.count)},
+		)
+	})
+
+	return []Family{family}
+}
+
+func withLabel(labels []Label, name string, value string) []Label {
+	return append(append([]Label(nil), labels...), Label{Name: name, Value: value})
+}
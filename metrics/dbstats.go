@@ -0,0 +1,39 @@
+package metrics
+
+import "database/sql"
+
+// DBStatsCollector exports the connection pool statistics of a sql.DB, read when metrics are collected.
+type DBStatsCollector struct {
+	db     *sql.DB
+	prefix string
+}
+
+// NewDBStatsCollector creates a DBStatsCollector whose metrics are named with the given prefix, e.g. contacts_db.
+func NewDBStatsCollector(db *sql.DB, prefix string) *DBStatsCollector {
+	return &DBStatsCollector{db: db, prefix: prefix}
+}
+
+func (c *DBStatsCollector) Collect() []Family {
+	stats := c.db.Stats()
+
+	family := func(name string, metricType string, help string, value float64) Family {
+		return Family{
+			Name:    c.prefix + "_" + name,
+			Help:    help,
+			Type:    metricType,
+			Samples: []Sample{{Value: value}},
+		}
+	}
+
+	return []Family{
+		family("max_open_connections", TypeGauge, "Maximum number of open connections to the database.", float64(stats.MaxOpenConnections)),
+		family("open_connections", TypeGauge, "Number of established connections, both in use and idle.", float64(stats.OpenConnections)),
+		family("in_use_connections", TypeGauge, "Number of connections currently in use.", float64(stats.InUse)),
+		family("idle_connections", TypeGauge, "Number of idle connections.", float64(stats.Idle)),
+		family("wait_count_total", TypeCounter, "Total number of connections waited for.", float64(stats.WaitCount)),
+		family("wait_duration_seconds_total", TypeCounter, "Total time blocked waiting for a new connection.", stats.WaitDuration.Seconds()),
+		family("max_idle_closed_total", TypeCounter, "Total number of connections closed due to max_idle_conns.", float64(stats.MaxIdleClosed)),
+		family("max_idle_time_closed_total", TypeCounter, "Total number of connections closed due to conn_max_idle_time.", float64(stats.MaxIdleTimeClosed)),
+		family("max_lifetime_closed_total", TypeCounter, "Total number of connections closed due to conn_max_lifetime.", float64(stats.MaxLifetimeClosed)),
+	}
+}
@@ -0,0 +1,180 @@
+// Package metrics exposes metrics in the Prometheus text format. Each subsystem defines its own metrics and registers
+// them with the Default registry, usually from an init function next to the code being measured, so that no central
+// list of metrics has to be edited.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric types, as named in the # TYPE line of the text format.
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// Collector is implemented by every metric, and by types that gather metrics from elsewhere when scraped, like
+// DBStatsCollector.
+type Collector interface {
+	Collect() []Family
+}
+
+// Family is a metric and its samples, e.g. every label combination of a counter.
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+// Sample is a single value of a Family. Suffix is appended to the family's name, e.g. _bucket for histograms.
+type Sample struct {
+	Suffix string
+	Labels []Label
+	Value  float64
+}
+
+// Label is a label name and value. Labels are kept in order, rather than in a map, so that output is stable.
+type Label struct {
+	Name  string
+	Value string
+}
+
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// ===== REGISTRY ======================================================================================================
+
+// Registry is a set of Collectors, with unique metric names, that is served by its ServeHTTP method.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+	names      map[string]bool
+}
+
+// Default is the Registry served at /metrics.
+var Default = NewRegistry()
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// Register adds a Collector to the registry. An error is returned if any of its metrics has an invalid name, or the
+// name of a metric that is already registered.
+func (r *Registry) Register(c Collector) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	families := c.Collect()
+	for _, family := range families {
+		if !nameRegexp.MatchString(family.Name) {
+			return fmt.Errorf("Invalid metric name %q", family.Name)
+		}
+		if r.names[family.Name] {
+			return fmt.Errorf("Metric %v is already registered", family.Name)
+		}
+	}
+
+	for _, family := range families {
+		r.names[family.Name] = true
+	}
+	r.collectors = append(r.collectors, c)
+	return nil
+}
+
+// MustRegister registers each Collector, panicking if any can't be registered.
+func (r *Registry) MustRegister(collectors ...Collector) {
+	for _, c := range collectors {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// MustRegister registers each Collector with the Default registry, panicking if any can't be registered.
+func MustRegister(collectors ...Collector) {
+	Default.MustRegister(collectors...)
+}
+
+// Gather collects every registered metric, ordered by name.
+func (r *Registry) Gather() []Family {
+	r.mu.Lock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	var families []Family
+	for _, c := range collectors {
+		families = append(families, c.Collect()...)
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].Name < families[j].Name })
+
+	return families
+}
+
+// ServeHTTP writes every registered metric in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	out := bufio.NewWriter(w)
+	WriteText(out, r.Gather())
+	out.Flush()
+}
+
+// ===== TEXT FORMAT ===================================================================================================
+
+// WriteText writes families in the Prometheus text exposition format.
+func WriteText(w *bufio.Writer, families []Family) {
+	for _, family := range families {
+		fmt.Fprintf(w, "# HELP %v %v\n", family.Name, escapeHelp(family.Help))
+		fmt.Fprintf(w, "# TYPE %v %v\n", family.Name, family.Type)
+
+		for _, sample := range family.Samples {
+			w.WriteString(family.Name)
+			w.WriteString(sample.Suffix)
+			if len(sample.Labels) > 0 {
+				w.WriteByte('{')
+				for i, label := range sample.Labels {
+					if i > 0 {
+						w.WriteByte(',')
+					}
+					fmt.Fprintf(w, `%v="%v"`, label.Name, escapeLabelValue(label.Value))
+				}
+				w.WriteByte('}')
+			}
+			w.WriteByte(' ')
+			w.WriteString(formatValue(sample.Value))
+			w.WriteByte('\n')
+		}
+	}
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(value)
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
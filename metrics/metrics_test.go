@@ -0,0 +1,82 @@
+package metrics_test
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/circleci/cci-demo-docker/metrics"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, registry *metrics.Registry) string {
+	w := httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+
+	return w.Body.String()
+}
+
+func Test_TextFormat(t *testing.T) {
+	// SETUP:
+	registry := metrics.NewRegistry()
+	requests := metrics.NewCounterVec("requests_total", "Requests.\nBy path.", "path")
+	latency := metrics.NewHistogramVec("latency_seconds", "Latency.", []float64{1, 0.1})
+	temperature := metrics.NewGaugeFunc("temperature", "Temperature.", func() float64 { return 21.5 })
+	registry.MustRegister(requests, latency, temperature)
+
+	// TEST:
+	requests.With(`/a"b`).Inc()
+	requests.With("/").Add(2)
+	latency.With().Observe(0.05)
+	latency.With().Observe(0.5)
+	latency.With().Observe(5)
+
+	// VERIFY: Families are ordered by name, and samples by label values.
+	assert.Equal(t, strings.Join([]string{
+		"# HELP latency_seconds Latency.",
+		"# TYPE latency_seconds histogram",
+		`latency_seconds_bucket{le="0.1"} 1`,
+		`latency_seconds_bucket{le="1"} 2`,
+		`latency_seconds_bucket{le="+Inf"} 3`,
+		"latency_seconds_sum 5.55",
+		"latency_seconds_count 3",
+		`# HELP requests_total Requests.\nBy path.`,
+		"# TYPE requests_total counter",
+		`requests_total{path="/"} 2`,
+		`requests_total{path="/a\"b"} 1`,
+		"# HELP temperature Temperature.",
+		"# TYPE temperature gauge",
+		"temperature 21.5",
+		"",
+	}, "\n"), scrape(t, registry))
+}
+
+func Test_RegisterRejectsDuplicates(t *testing.T) {
+	registry := metrics.NewRegistry()
+	require.NoError(t, registry.Register(metrics.NewCounterVec("things_total", "Things.")))
+
+	assert.EqualError(t, registry.Register(metrics.NewCounterVec("things_total", "Things.")), "Metric things_total is already registered")
+	assert.EqualError(t, registry.Register(metrics.NewCounterVec("things-total", "Things.")), `Invalid metric name "things-total"`)
+}
+
+func Test_DBStatsCollector(t *testing.T) {
+	// The pool isn't connected until it is used, so no database is needed.
+	sqlDB, err := sql.Open("postgres", "postgres://localhost/none")
+	require.NoError(t, err)
+	defer sqlDB.Close()
+	sqlDB.SetMaxOpenConns(7)
+
+	registry := metrics.NewRegistry()
+	registry.MustRegister(metrics.NewDBStatsCollector(sqlDB, "test_db"))
+
+	out := scrape(t, registry)
+	assert.Contains(t, out, "test_db_max_open_connections 7\n")
+	assert.Contains(t, out, "test_db_open_connections 0\n")
+	assert.Contains(t, out, "# TYPE test_db_wait_count_total counter\n")
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/circleci/cci-demo-docker/db"
+)
+
+// migrateCommands are the subcommands of `contacts migrate`.
+var migrateCommands []command
+
+func init() {
+	migrateCommands = []command{
+		{"status", "status       List applied and pending migrations, exits 3 if any are pending", runMigrateStatus},
+		{"up", "up [N]       Apply all pending migrations, or the next N", runMigrateUp},
+		{"down", "down N       Roll back the last N migrations", runMigrateDown},
+		{"goto", "goto V       Migrate up or down to version V (0 rolls back everything)", runMigrateGoto},
+		{"list", "list         List the migration files in use, with their SHA-256 checksums", runMigrateList},
+		{"create", "create NAME  Scaffold numbered up/down files for a new migration", runMigrateCreate},
+		{"reset", "reset        Roll back and reapply every migration, DESTROYING ALL DATA (CONTACTS_ENV=development only)", runMigrateReset},
+		{"help", "help         Show this help", runMigrateHelp},
+	}
+}
+
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		printMigrateUsage(os.Stderr)
+		return exitUsage
+	}
+
+	for _, cmd := range migrateCommands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "contacts: unknown migrate command %q\n\n", args[0])
+	printMigrateUsage(os.Stderr)
+	return exitUsage
+}
+
+func runMigrateHelp(args []string) int {
+	printMigrateUsage(os.Stdout)
+	return exitOK
+}
+
+func printMigrateUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: contacts migrate COMMAND [ARGS...]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range migrateCommands {
+		fmt.Fprintln(w, "  "+cmd.usage)
+	}
+}
+
+func runMigrateStatus(args []string) int {
+	cfg, args, code := loadConfig("migrate status", args)
+	if cfg == nil {
+		return code
+	}
+	if len(args) != 0 {
+		return usageError("migrate status takes no arguments")
+	}
+	if err := cfg.RequireDB(); err != nil {
+		return fail(err)
+	}
+
+	statuses, err := db.Status(cfg.DB.OwnerURL(), cfg.DB.Migrations)
+	if err != nil {
+		return fail(err)
+	}
+
+	pending := false
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS")
+	for _, status := range statuses {
+		name, state := status.Name, "applied"
+		if !status.Known() {
+			name, state = "?", "applied, unknown to this binary"
+		} else if !status.Applied {
+			state = "pending"
+			pending = true
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\n", status.Version, name, state)
+	}
+	w.Flush()
+
+	if pending {
+		return exitPending
+	}
+	return exitOK
+}
+
+func runMigrateUp(args []string) int {
+	cfg, args, code := loadConfig("migrate up", args)
+	if cfg == nil {
+		return code
+	}
+	if len(args) > 1 {
+		return usageError("usage: contacts migrate up [FLAGS] [N]")
+	}
+	if err := cfg.RequireDB(); err != nil {
+		return fail(err)
+	}
+
+	var err error
+	if len(args) == 0 {
+		err = db.Up(cfg.DB.OwnerURL(), cfg.DB.Migrations)
+	} else {
+		n, parseErr := parsePositive(args[0])
+		if parseErr != nil {
+			return usageError("migrate up: %v", parseErr)
+		}
+		err = db.Steps(cfg.DB.OwnerURL(), cfg.DB.Migrations, n)
+	}
+
+	if err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+func runMigrateDown(args []string) int {
+	cfg, args, code := loadConfig("migrate down", args)
+	if cfg == nil {
+		return code
+	}
+	if len(args) != 1 {
+		return usageError("usage: contacts migrate down [FLAGS] N")
+	}
+
+	n, err := parsePositive(args[0])
+	if err != nil {
+		return usageError("migrate down: %v", err)
+	}
+	if err := cfg.RequireDB(); err != nil {
+		return fail(err)
+	}
+
+	if err := db.Steps(cfg.DB.OwnerURL(), cfg.DB.Migrations, -n); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+func runMigrateGoto(args []string) int {
+	cfg, args, code := loadConfig("migrate goto", args)
+	if cfg == nil {
+		return code
+	}
+	if len(args) != 1 {
+		return usageError("usage: contacts migrate goto [FLAGS] V")
+	}
+
+	target, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return usageError("migrate goto: invalid version %q", args[0])
+	}
+	if err := cfg.RequireDB(); err != nil {
+		return fail(err)
+	}
+
+	if err := db.Goto(cfg.DB.OwnerURL(), cfg.DB.Migrations, target); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+func runMigrateList(args []string) int {
+	cfg, args, code := loadConfig("migrate list", args)
+	if cfg == nil {
+		return code
+	}
+	if len(args) != 0 {
+		return usageError("migrate list takes no arguments")
+	}
+
+	sqlFiles := cfg.DB.Migrations
+	files, err := db.List(sqlFiles)
+	if err != nil {
+		return fail(err)
+	}
+
+	if sqlFiles == "" {
+		fmt.Println("Source: embedded")
+	} else {
+		fmt.Printf("Source: %v\n", sqlFiles)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tSIZE\tSHA256")
+	for _, f := range files {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", f.Name, f.Size, f.SHA256)
+	}
+	w.Flush()
+
+	return exitOK
+}
+
+func runMigrateCreate(args []string) int {
+	cfg, args, code := loadConfig("migrate create", args)
+	if cfg == nil {
+		return code
+	}
+	if len(args) != 1 {
+		return usageError("usage: contacts migrate create [FLAGS] NAME")
+	}
+
+	// New migrations are always created on disk, so they can be embedded by the next build.
+	sqlFiles := cfg.DB.Migrations
+	if sqlFiles == "" {
+		sqlFiles = "./db/migrations"
+	}
+
+	upFile, downFile, err := db.Create(sqlFiles, args[0])
+	if err != nil {
+		return fail(err)
+	}
+
+	fmt.Println(upFile)
+	fmt.Println(downFile)
+	return exitOK
+}
+
+func runMigrateReset(args []string) int {
+	cfg, args, code := loadConfig("migrate reset", args)
+	if cfg == nil {
+		return code
+	}
+	if len(args) != 0 {
+		return usageError("migrate reset takes no arguments")
+	}
+
+	if os.Getenv("CONTACTS_ENV") != "development" {
+		return fail(errors.New("migrate reset destroys all data and only runs with CONTACTS_ENV=development"))
+	}
+	if err := cfg.RequireDB(); err != nil {
+		return fail(err)
+	}
+
+	if err := db.Reset(cfg.DB.OwnerURL(), cfg.DB.Migrations); err != nil {
+		return fail(err)
+	}
+
+	fmt.Println("Database reset")
+	return exitOK
+}
+
+func parsePositive(arg string) (int, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("expected a positive number of migrations, got %q", arg)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/circleci/cci-demo-docker/config"
+	"github.com/circleci/cci-demo-docker/db"
+	"github.com/circleci/cci-demo-docker/service"
+)
+
+func runSchema(args []string) int {
+	if len(args) == 0 || args[0] != "check" {
+		return usageError("usage: contacts schema check [FLAGS]")
+	}
+
+	cfg, args, code := loadConfig("schema check", args[1:])
+	if cfg == nil {
+		return code
+	}
+	if len(args) != 0 {
+		return usageError("schema check takes no arguments")
+	}
+	if err := cfg.RequireDB(); err != nil {
+		return fail(err)
+	}
+
+	drift, err := db.CheckSchema(cfg.DB.OwnerURL(), cfg.DB.Migrations)
+	if err != nil {
+		return fail(err)
+	}
+
+	fmt.Print(drift.String())
+	if !drift.Empty() {
+		return exitError
+	}
+	return exitOK
+}
+
+// checkSchemaAtStartup fails readiness when db.schema_check is enabled and the database has drifted from the
+// migrations, so that a drifted replica never receives traffic.
+func checkSchemaAtStartup(cfg *config.Config, server *service.Server) {
+	if !cfg.DB.SchemaCheck {
+		return
+	}
+
+	drift, err := db.CheckSchema(cfg.DB.OwnerURL(), cfg.DB.Migrations)
+	if err != nil {
+		slog.Error("Unable to check schema", "error", err.Error())
+		server.Readiness.Fail("schema", "unable to check schema")
+		return
+	}
+
+	if !drift.Empty() {
+		slog.Warn("Database schema drifted from the migrations", "drift", drift.String())
+		server.Readiness.Fail("schema", fmt.Sprintf(
+			"schema drifted from migrations (%v missing, %v unexpected)",
+			len(drift.Missing),
+			len(drift.Unexpected),
+		))
+	}
+}
+
+// checkRowLevelSecurity returns an error if row-level security is disabled on a table owned by accounts, or doesn't
+// apply to the service's role, e.g. because it is a superuser, so that the service never starts without it. Policies
+// that don't apply are only warned about when db.allow_rls_bypass is set, as development databases often have a single
+// role.
+func checkRowLevelSecurity(database *service.Database, allowBypass bool) error {
+	enforced, err := database.CheckRowLevelSecurity(context.Background())
+	if err != nil || enforced {
+		return err
+	}
+
+	if !allowBypass {
+		return errors.New("row-level security doesn't apply to the database role, which owns the tables or bypasses it; set db.url to a member of contacts_app, or set db.allow_rls_bypass")
+	}
+	slog.Warn("Row-level security doesn't apply to the database role, which owns the tables or bypasses it; set db.url to a member of contacts_app")
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/circleci/cci-demo-docker/service"
+)
+
+func runSeed(args []string) int {
+	var upsert bool
+	cfg, args, code := loadConfig("seed", args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&upsert, "upsert", false, "update existing records instead of failing, so seeding can be re-run")
+	})
+	if cfg == nil {
+		return code
+	}
+	if len(args) != 1 {
+		return usageError("usage: contacts seed [-upsert] [FLAGS] FILE")
+	}
+
+	fixtures, err := service.ReadFixtures(args[0])
+	if err != nil {
+		return fail(err)
+	}
+
+	database := SetupDB(cfg)
+	defer database.Close()
+
+	if _, err := database.LoadFixtures(context.Background(), fixtures, upsert); err != nil {
+		return fail(err)
+	}
+
+	fmt.Printf("Seeded %v accounts and %v contacts\n", len(fixtures.Accounts), len(fixtures.Contacts))
+	return exitOK
+}
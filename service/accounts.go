@@ -0,0 +1,223 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Account is a tenant of the service, e.g. a team. Every contact and API key belongs to an account, and callers only
+// see the contacts of their own account.
+type Account struct {
+	Id        int
+	Name      string
+	CreatedAt time.Time
+}
+
+// DefaultAccountID is the account created by the migration that introduced accounts. Contacts and API keys that existed
+// before then belong to it, and so do requests to a server without authentication.
+const DefaultAccountID = 1
+
+// ErrNoAccount is panicked by Transaction queries that are scoped to an account, when the transaction has none.
+var ErrNoAccount = errors.New("No account to scope the query to")
+
+// WithAccount returns a copy of ctx scoping the transactions started with it to the account with the given ID.
+func WithAccount(ctx context.Context, accountID int) context.Context {
+	return context.WithValue(ctx, accountKey, accountID)
+}
+
+// AccountFromContext returns the ID of the account that transactions started with ctx are scoped to, or false if there
+// is none.
+func AccountFromContext(ctx context.Context) (int, bool) {
+	accountID, ok := ctx.Value(accountKey).(int)
+	return accountID, ok && accountID != 0
+}
+
+// Account returns the ID of the account that the transaction's queries are scoped to. It panics with ErrNoAccount if
+// there is none, so that a query is never run across every account by mistake.
+func (tx *Transaction) Account() int {
+	if tx.accountID == 0 {
+		panic(ErrNoAccount)
+	}
+
+	return tx.accountID
+}
+
+// SetAccount scopes the queries that follow to another account, e.g. when loading fixtures for several accounts.
+func (tx *Transaction) SetAccount(accountID int) {
+	if err := tx.scope(accountID); err != nil {
+		panic(err)
+	}
+}
+
+// accountSetting is the Postgres setting that row-level security policies compare the account_id of rows with, so that
+// a query missing its account filter still can't see other accounts' rows.
+const accountSetting = "contacts.account_id"
+
+// scope scopes the transaction to the account, setting accountSetting until the transaction ends. It isn't reported to
+// the Database's hooks, as it is run for every transaction.
+func (tx *Transaction) scope(accountID int) error {
+	if _, err := tx.Tx.ExecContext(tx.ctx, fmt.Sprintf("SET LOCAL %v = '%d'", accountSetting, accountID)); err != nil {
+		return fmt.Errorf("Unable to scope transaction to account %v: %w", accountID, err)
+	}
+
+	tx.accountID = accountID
+	return nil
+}
+
+// ===== ROW-LEVEL SECURITY ============================================================================================
+
+// CheckRowLevelSecurity returns an error naming the tables owned by accounts, i.e. those with an account_id column, that
+// don't have row-level security enabled. It also reports whether the policies are enforced for the role the Database
+// connects as, which they aren't if it owns the tables, or is a superuser.
+func (db *Database) CheckRowLevelSecurity(ctx context.Context) (enforced bool, err error) {
+	var disabled []string
+	err = db.Read(ctx, func(tx *Transaction) {
+		disabled, enforced = tx.CheckRowLevelSecurity()
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(disabled) > 0 {
+		return false, fmt.Errorf("Row-level security is disabled on %v", strings.Join(disabled, ", "))
+	}
+
+	return enforced, nil
+}
+
+// CheckRowLevelSecurity returns the tenant tables without row-level security within the transaction, and whether the
+// policies of the others are enforced for the current role.
+func (tx *Transaction) CheckRowLevelSecurity() (disabled []string, enforced bool) {
+	rows, err := tx.Query(`
+		SELECT c.relname,
+		       c.relrowsecurity,
+		       c.relforcerowsecurity OR NOT pg_has_role(current_user, c.relowner, 'USAGE'),
+		       (SELECT NOT (rolsuper OR rolbypassrls) FROM pg_roles WHERE rolname = current_user)
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = current_schema()
+		  AND c.relkind = 'r'
+		  AND EXISTS (SELECT 1 FROM pg_attribute a WHERE a.attrelid = c.oid AND a.attname = 'account_id' AND NOT a.attisdropped)
+		ORDER BY c.relname`)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	enforced = true
+	for rows.Next() {
+		var table string
+		var enabled, appliesToOwner, appliesToRole bool
+		if err := rows.Scan(&table, &enabled, &appliesToOwner, &appliesToRole); err != nil {
+			panic(err)
+		}
+
+		if !enabled {
+			disabled = append(disabled, table)
+		}
+		enforced = enforced && appliesToOwner && appliesToRole
+	}
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	return disabled, enforced
+}
+
+// ===== CREATE ACCOUNT ================================================================================================
+
+// CreateAccount creates an account with the given name, or returns the account that already has it.
+func (db *Database) CreateAccount(ctx context.Context, name string) (*Account, error) {
+	var account *Account
+	err := db.Write(ctx, func(tx *Transaction) {
+		account = tx.CreateAccount(name)
+	})
+
+	return account, err
+}
+
+// CreateAccount creates an account within the transaction, or returns the account that already has the name.
+func (tx *Transaction) CreateAccount(name string) *Account {
+	row := tx.QueryRow(
+		"INSERT INTO accounts (name) VALUES ($1) ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name RETURNING "+accountColumns,
+		name,
+	)
+
+	account, err := scanAccount(row)
+	if err != nil {
+		panic(err)
+	}
+
+	return account
+}
+
+// ===== ACCOUNT EXISTS ================================================================================================
+
+// AccountExists reports whether there is an account with the given ID.
+func (db *Database) AccountExists(ctx context.Context, accountID int) (bool, error) {
+	var exists bool
+	err := db.Read(ctx, func(tx *Transaction) {
+		exists = tx.AccountExists(accountID)
+	})
+
+	return exists, err
+}
+
+// AccountExists reports whether there is an account with the given ID within the transaction.
+func (tx *Transaction) AccountExists(accountID int) bool {
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM accounts WHERE id = $1)", accountID).Scan(&exists); err != nil {
+		panic(err)
+	}
+
+	return exists
+}
+
+// ===== LIST ACCOUNTS =================================================================================================
+
+// ListAccounts reads every account, oldest first.
+func (db *Database) ListAccounts(ctx context.Context) ([]*Account, error) {
+	var accounts []*Account
+	err := db.Read(ctx, func(tx *Transaction) {
+		accounts = tx.ListAccounts()
+	})
+
+	return accounts, err
+}
+
+// ListAccounts reads every account within the transaction.
+func (tx *Transaction) ListAccounts() []*Account {
+	rows, err := tx.Query("SELECT " + accountColumns + " FROM accounts ORDER BY id")
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var accounts []*Account
+	for rows.Next() {
+		account, err := scanAccount(rows)
+		if err != nil {
+			panic(err)
+		}
+		accounts = append(accounts, account)
+	}
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	return accounts
+}
+
+// accountColumns are the columns read by scanAccount, in order.
+const accountColumns = "id, name, created_at"
+
+func scanAccount(row interface{ Scan(...interface{}) error }) (*Account, error) {
+	var account Account
+	if err := row.Scan(&account.Id, &account.Name, &account.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
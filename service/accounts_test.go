@@ -0,0 +1,149 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/circleci/cci-demo-docker/service"
+	"github.com/circleci/cci-demo-docker/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_AccountIsolation(t *testing.T) {
+	env := test.SetupEnv(t)
+	defer env.Close()
+
+	// SETUP: Alice is a contact of the default account, and another account has a client of its own.
+	env.SetupContact("alice@example.xyz", "Alice Zulu")
+	acme, acmeClient := env.SetupAccount("Acme")
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: reading a contact of another account
+	{
+		contact, err := acmeClient.GetContactByEmail("alice@example.xyz")
+
+		// VERIFY: It is as if the contact doesn't exist.
+		require.IsType(t, service.ErrorResponse{}, err)
+		assert.Equal(t, http.StatusNotFound, err.(service.ErrorResponse).StatusCode)
+		assert.Nil(t, contact)
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: adding a contact with an email another account already uses
+	{
+		contact, err := acmeClient.AddContact(service.AddContactRequest{Email: "alice@example.xyz", Name: "Alice Xray"})
+
+		// VERIFY: Each account has its own contact.
+		require.NoError(t, err)
+		assert.Equal(t, "Alice Xray", env.ReadAccountContactWithEmail(acme.Id, "alice@example.xyz").Name)
+		assert.Equal(t, contact.Id, env.ReadAccountContactWithEmail(acme.Id, "alice@example.xyz").Id)
+		assert.Equal(t, "Alice Zulu", env.ReadContactWithEmail("alice@example.xyz").Name)
+
+		original, err := env.Client.GetContactByEmail("alice@example.xyz")
+		require.NoError(t, err)
+		assert.Equal(t, "Alice Zulu", original.Name)
+	}
+}
+
+func Test_TransactionRequiresAccount(t *testing.T) {
+	env := test.SetupEnv(t)
+	defer env.Close()
+
+	// TEST: Querying contacts without an account.
+	_, err := env.DB.GetContactByEmail(context.Background(), "alice@example.xyz")
+
+	// VERIFY: The query is refused, rather than run across every account.
+	assert.Equal(t, service.ErrNoAccount, err)
+}
+
+func Test_RowLevelSecurity(t *testing.T) {
+	env := test.SetupEnv(t)
+	defer env.Close()
+
+	// SETUP: A contact in each of two accounts, and an API key in each, besides the one of the Env's Client.
+	seeded := env.LoadFixtures("accounts.yaml")
+	acme := seeded.Accounts["acme"].Id
+	env.CreateAPIKey(acme)
+	ctx := context.Background()
+
+	// count counts the rows of a table visible to the service's role without filtering on the account, as a query that
+	// forgot its WHERE clause would. An accountID of 0 doesn't scope the transaction.
+	count := func(table string, accountID int) (int, error) {
+		tx, err := env.DB.DB.Begin()
+		require.NoError(t, err)
+		defer tx.Rollback()
+
+		_, err = tx.Exec("SET LOCAL ROLE contacts_app")
+		require.NoError(t, err)
+		if accountID != 0 {
+			_, err = tx.Exec(fmt.Sprintf("SET LOCAL contacts.account_id = '%d'", accountID))
+			require.NoError(t, err)
+		}
+
+		var n int
+		err = tx.QueryRow("SELECT count(*) FROM " + table).Scan(&n)
+		return n, err
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: querying every contact and API key
+	for _, table := range []string{"contacts", "api_keys"} {
+		scoped, scopedErr := count(table, acme)
+		unscoped, unscopedErr := count(table, 0)
+
+		// VERIFY: Only the rows of the transaction's account are visible, and none without one.
+		require.NoError(t, scopedErr, table)
+		assert.Equal(t, 1, scoped, table)
+		require.NoError(t, unscopedErr, table)
+		assert.Equal(t, 0, unscoped, table)
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: the startup check
+	{
+		_, err := env.DB.CheckRowLevelSecurity(ctx)
+		require.NoError(t, err)
+
+		owner := test.OwnerDB(t)
+		defer owner.Close()
+		_, err = owner.Exec("ALTER TABLE contacts DISABLE ROW LEVEL SECURITY")
+		require.NoError(t, err)
+		defer owner.Exec("ALTER TABLE contacts ENABLE ROW LEVEL SECURITY")
+
+		_, err = env.DB.CheckRowLevelSecurity(ctx)
+
+		// VERIFY: It fails once row-level security is disabled, naming the table.
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "contacts")
+	}
+}
+
+func Test_JWTAccountMustExist(t *testing.T) {
+	env := test.SetupEnv(t)
+	defer env.Close()
+
+	// SETUP: JWTs naming the caller's account, checked against the database.
+	issuer, authenticator, cleanup := setupIssuer(t)
+	defer cleanup()
+	authenticator.AccountClaim = "account_id"
+	authenticator.DB = env.DB
+	acme, _ := env.SetupAccount("Acme")
+	withID := func(id int) string {
+		claims := issuer.Claims("alice")
+		claims["account_id"] = id
+		return issuer.Sign("rs256-1", claims)
+	}
+
+	// TEST:
+	principal, err := authenticator.Authenticate(context.Background(), withID(acme.Id))
+	_, unknownErr := authenticator.Authenticate(context.Background(), withID(acme.Id+1000))
+
+	// VERIFY: A token naming an account that doesn't exist is rejected.
+	require.NoError(t, err)
+	assert.Equal(t, acme.Id, principal.AccountID)
+	assert.True(t, errors.Is(unknownErr, service.ErrInvalidToken), "%v", unknownErr)
+}
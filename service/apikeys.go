@@ -0,0 +1,332 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APIKey describes a key that clients authenticate with. The key itself is only known when it is created, the database
+// keeps a SHA-256 hash of it, and its prefix to find it by.
+type APIKey struct {
+	Id int
+	// AccountId is the account that the key's callers belong to.
+	AccountId int
+	// Prefix is the start of the key, like ck_3f9a2b7c1d0e, which identifies it without revealing it.
+	Prefix     string
+	Name       string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// Active reports whether the key can be used at the given time.
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// ErrAPIKeyNotFound is returned when revoking a key that doesn't exist, or was already revoked.
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// apiKeyPrefix starts every API key, so that they are recognizable, e.g. by secret scanners.
+const apiKeyPrefix = "ck_"
+
+// lastUsedResolution is how out of date the last-used time of a key may be, so that it isn't written on every request.
+const lastUsedResolution = time.Minute
+
+// GenerateAPIKey generates a new random key, like ck_3f9a2b7c1d0e_<43 characters>. It returns the key, its prefix and
+// its hash.
+func GenerateAPIKey() (key string, prefix string, hash []byte) {
+	var id [6]byte
+	var secret [32]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(err)
+	}
+	if _, err := rand.Read(secret[:]); err != nil {
+		panic(err)
+	}
+
+	prefix = apiKeyPrefix + hex.EncodeToString(id[:])
+	key = prefix + "_" + base64.RawURLEncoding.EncodeToString(secret[:])
+	return key, prefix, hashAPIKey(key)
+}
+
+// hashAPIKey hashes a key for storage. Keys are long and random, so a fast hash is enough.
+func hashAPIKey(key string) []byte {
+	hash := sha256.Sum256([]byte(key))
+	return hash[:]
+}
+
+// parseAPIKey returns the prefix of key, or false if it isn't an API key.
+func parseAPIKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", false
+	}
+
+	prefix, secret, ok := strings.Cut(key[len(apiKeyPrefix):], "_")
+	if !ok || prefix == "" || secret == "" {
+		return "", false
+	}
+
+	return apiKeyPrefix + prefix, true
+}
+
+// ===== CREATE API KEY ================================================================================================
+
+// CreateAPIKey generates a key for the account with the given name and scopes, which never expires if expiresAt is nil.
+// The key is returned along with its description, and can't be retrieved again.
+func (db *Database) CreateAPIKey(ctx context.Context, accountID int, name string, scopes []string, expiresAt *time.Time) (string, *APIKey, error) {
+	key, prefix, hash := GenerateAPIKey()
+
+	var apiKey *APIKey
+	err := db.Write(WithAccount(ctx, accountID), func(tx *Transaction) {
+		apiKey = tx.CreateAPIKey(prefix, hash, name, scopes, expiresAt)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return key, apiKey, nil
+}
+
+// CreateAPIKey inserts a key for the transaction's account.
+func (tx *Transaction) CreateAPIKey(prefix string, hash []byte, name string, scopes []string, expiresAt *time.Time) *APIKey {
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	row := tx.QueryRow(
+		"INSERT INTO api_keys (account_id, prefix, hash, name, scopes, expires_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING "+apiKeyColumns,
+		tx.Account(),
+		prefix,
+		hash,
+		name,
+		pq.Array(scopes),
+		expiresAt,
+	)
+
+	apiKey, err := scanAPIKey(row)
+	if err != nil {
+		panic(err)
+	}
+
+	return apiKey
+}
+
+// ===== LIST API KEYS =================================================================================================
+
+// ListAPIKeys reads the keys of every account, including revoked and expired ones, oldest first.
+func (db *Database) ListAPIKeys(ctx context.Context) ([]*APIKey, error) {
+	var apiKeys []*APIKey
+	err := db.Read(ctx, func(tx *Transaction) {
+		apiKeys = tx.ListAPIKeys()
+	})
+
+	return apiKeys, err
+}
+
+// ListAPIKeys reads the keys of every account within the transaction. Each account's keys are read scoped to it, as
+// row-level security hides the others.
+func (tx *Transaction) ListAPIKeys() []*APIKey {
+	var apiKeys []*APIKey
+	for _, account := range tx.ListAccounts() {
+		tx.SetAccount(account.Id)
+		apiKeys = append(apiKeys, tx.listAccountAPIKeys()...)
+	}
+	sort.Slice(apiKeys, func(i, j int) bool { return apiKeys[i].Id < apiKeys[j].Id })
+
+	return apiKeys
+}
+
+// listAccountAPIKeys reads the keys of the transaction's account.
+func (tx *Transaction) listAccountAPIKeys() []*APIKey {
+	rows, err := tx.Query("SELECT "+apiKeyColumns+" FROM api_keys WHERE account_id = $1 ORDER BY id", tx.Account())
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var apiKeys []*APIKey
+	for rows.Next() {
+		apiKey, err := scanAPIKey(rows)
+		if err != nil {
+			panic(err)
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	return apiKeys
+}
+
+// ===== REVOKE API KEY ================================================================================================
+
+// RevokeAPIKey revokes the key with the given prefix, so that it can't be used anymore. ErrAPIKeyNotFound is returned
+// if there is no such key, or it was already revoked.
+func (db *Database) RevokeAPIKey(ctx context.Context, prefix string) error {
+	var revoked bool
+	err := db.Write(ctx, func(tx *Transaction) {
+		revoked = tx.RevokeAPIKey(prefix)
+	})
+	if err == nil && !revoked {
+		err = ErrAPIKeyNotFound
+	}
+
+	return err
+}
+
+// RevokeAPIKey revokes a key of any account within the transaction, reporting whether it was found.
+func (tx *Transaction) RevokeAPIKey(prefix string) bool {
+	apiKey, _, _ := tx.findAPIKey(prefix)
+	if apiKey == nil {
+		return false
+	}
+
+	tx.SetAccount(apiKey.AccountId)
+	result, err := tx.Exec(
+		"UPDATE api_keys SET revoked_at = now() WHERE account_id = $1 AND prefix = $2 AND revoked_at IS NULL",
+		tx.Account(),
+		prefix,
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		panic(err)
+	}
+
+	return n > 0
+}
+
+// ===== AUTHENTICATE ==================================================================================================
+
+// APIKeyAuthenticator authenticates requests with the API keys in a Database.
+type APIKeyAuthenticator struct {
+	DB *Database
+}
+
+// Authenticate checks that key is an active API key, and records that it was used.
+func (a *APIKeyAuthenticator) Authenticate(ctx context.Context, key string) (*Principal, error) {
+	prefix, ok := parseAPIKey(key)
+	if !ok {
+		return nil, ErrTokenNotRecognized
+	}
+
+	var apiKey *APIKey
+	err := a.DB.Write(ctx, func(tx *Transaction) {
+		apiKey = tx.AuthenticateAPIKey(prefix, hashAPIKey(key))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == nil {
+		return nil, ErrInvalidToken
+	}
+
+	return &Principal{ID: "apikey:" + apiKey.Prefix, Name: apiKey.Name, Scopes: apiKey.Scopes, AccountID: apiKey.AccountId}, nil
+}
+
+// AuthenticateAPIKey finds the active key with the given prefix and hash within the transaction, updating the time it
+// was last used. Nil is returned if there is no such key. Keys are found across accounts, as the account of the caller
+// isn't known until then, and the transaction is scoped to the key's account once it is found.
+func (tx *Transaction) AuthenticateAPIKey(prefix string, hash []byte) *APIKey {
+	apiKey, storedHash, now := tx.findAPIKey(prefix)
+	if apiKey == nil {
+		return nil
+	}
+
+	if subtle.ConstantTimeCompare(storedHash, hash) != 1 || !apiKey.Active(now) {
+		return nil
+	}
+
+	tx.SetAccount(apiKey.AccountId)
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= lastUsedResolution {
+		if _, err := tx.Exec("UPDATE api_keys SET last_used_at = $2 WHERE id = $1", apiKey.Id, now); err != nil {
+			panic(err)
+		}
+		apiKey.LastUsedAt = &now
+	}
+
+	return apiKey
+}
+
+// apiKeyPrefixSetting is the Postgres setting that makes the key with that prefix visible, whichever account it belongs
+// to, so that it can be found before the caller's account is known. Row-level security hides the other keys.
+const apiKeyPrefixSetting = "contacts.api_key_prefix"
+
+// findAPIKey reads the key with the given prefix in any account, its hash, and the current time. Nil is returned if
+// there is no such key.
+func (tx *Transaction) findAPIKey(prefix string) (apiKey *APIKey, hash []byte, now time.Time) {
+	if _, err := tx.Exec("SELECT set_config($1, $2, true)", apiKeyPrefixSetting, prefix); err != nil {
+		panic(err)
+	}
+
+	row := tx.QueryRow("SELECT hash, now(), "+apiKeyColumns+" FROM api_keys WHERE prefix = $1", prefix)
+	apiKey, err := scanAPIKey(row, &hash, &now)
+	if err == sql.ErrNoRows {
+		return nil, nil, now
+	} else if err != nil {
+		panic(err)
+	}
+
+	return apiKey, hash, now
+}
+
+// apiKeyColumns are the columns read by scanAPIKey, in order.
+const apiKeyColumns = "id, account_id, prefix, name, scopes, created_at, expires_at, last_used_at, revoked_at"
+
+// scanAPIKey scans the columns in apiKeyColumns, after any leading columns scanned into dest.
+func scanAPIKey(row interface{ Scan(...interface{}) error }, dest ...interface{}) (*APIKey, error) {
+	var apiKey APIKey
+	err := row.Scan(append(
+		dest,
+		&apiKey.Id,
+		&apiKey.AccountId,
+		&apiKey.Prefix,
+		&apiKey.Name,
+		pq.Array(&apiKey.Scopes),
+		&apiKey.CreatedAt,
+		&apiKey.ExpiresAt,
+		&apiKey.LastUsedAt,
+		&apiKey.RevokedAt,
+	)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiKey, nil
+}
+
+// ParseScopes parses a comma separated list of scopes, like "contacts:read,contacts:write".
+func ParseScopes(value string) ([]string, error) {
+	scopes := []string{}
+	for _, scope := range strings.Split(value, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		}
+		if strings.ContainsAny(scope, " \t\"\\") {
+			return nil, fmt.Errorf("Invalid scope %q", scope)
+		}
+		scopes = append(scopes, scope)
+	}
+
+	return scopes, nil
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	// ID identifies the caller across requests, e.g. "apikey:ck_3f9a2b7c1d0e", and is used to rate limit it.
+	ID string
+	// Name describes the caller for humans, e.g. the name of an API key.
+	Name   string
+	Scopes []string
+	// AccountID is the account the caller belongs to, which every query of the request is scoped to.
+	AccountID int
+	// Claims are the claims of the caller's JWT, or nil if it used another kind of token.
+	Claims map[string]interface{}
+}
+
+// HasScope reports whether the principal was granted scope.
+func (p *Principal) HasScope(scope string) bool {
+	for _, granted := range p.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithPrincipal returns a copy of ctx carrying the authenticated caller.
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+// PrincipalFromContext returns the authenticated caller of the request, or nil if it wasn't authenticated.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey).(*Principal)
+	return principal
+}
+
+// ===== AUTHENTICATION ================================================================================================
+
+// An Authenticator checks the bearer token of a request. Authenticators return ErrTokenNotRecognized for tokens of
+// another kind, so that the next one can be tried.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*Principal, error)
+}
+
+var (
+	// ErrTokenNotRecognized is returned by an Authenticator for tokens it doesn't issue.
+	ErrTokenNotRecognized = errors.New("Token not recognized")
+
+	// ErrInvalidToken is returned by an Authenticator for tokens that are malformed, unknown, expired or revoked.
+	ErrInvalidToken = errors.New("Invalid token")
+)
+
+// WithAuthentication requires requests to routes that aren't Public to be authenticated with an `Authorization:
+// Bearer` header, checked by the first of the authenticators that recognizes the token, and to be granted the scope of
+// the route's Permission. Requests are scoped to the account of the caller. Without authenticators, every route is
+// public, and requests are scoped to the default account.
+func WithAuthentication(authenticators ...Authenticator) ServerOption {
+	return func(s *Server) {
+		s.authenticators = append(s.authenticators, authenticators...)
+	}
+}
+
+// authenticate wraps the handler of a route to require authentication, if it is enabled and the route isn't Public.
+// The request is scoped to the caller's account.
+func (s *Server) authenticate(permission Permission, handler httprouter.Handle) httprouter.Handle {
+	if permission == Public {
+		return handler
+	}
+	if len(s.authenticators) == 0 {
+		return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			handler(w, r.WithContext(WithAccount(r.Context(), DefaultAccountID)), ps)
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		// Failed authentications are limited before the token is looked up, as route limits only apply once the
+		// client has a principal. Every attempt is charged up front, so that concurrent guesses can't get past the
+		// limit, and refunded unless the token is rejected.
+		if !s.takeAuthenticationToken(w, r) {
+			return
+		}
+
+		token, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="contacts"`)
+			writeJSONError(w, r, http.StatusUnauthorized, "An `Authorization: Bearer` header is required")
+			return
+		}
+
+		principal, err := s.authenticateToken(r.Context(), token)
+		if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrTokenNotRecognized) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="contacts", error="invalid_token"`)
+			writeJSONError(w, r, http.StatusUnauthorized, err.Error())
+			return
+		}
+		s.refundAuthenticationToken(r)
+		if errors.Is(err, ErrKeysUnavailable) {
+			// The error names where the keys are loaded from, so it is only logged.
+			s.logger.WarnContext(r.Context(), "Unable to verify token", "error", err.Error())
+			w.Header().Set("Retry-After", strconv.Itoa(int(MinJWKSRefreshInterval.Seconds())))
+			writeJSONError(w, r, http.StatusServiceUnavailable, "Tokens can't be verified at the moment")
+			return
+		} else if err != nil {
+			s.writeUnexpectedError(w, r, err)
+			return
+		}
+
+		ctx := WithAccount(WithPrincipal(r.Context(), principal), principal.AccountID)
+		handler(w, r.WithContext(ctx), ps)
+	}
+}
+
+func (s *Server) authenticateToken(ctx context.Context, token string) (*Principal, error) {
+	for _, authenticator := range s.authenticators {
+		principal, err := authenticator.Authenticate(ctx, token)
+		if !errors.Is(err, ErrTokenNotRecognized) {
+			return principal, err
+		}
+	}
+
+	return nil, ErrTokenNotRecognized
+}
+
+// ===== AUTHORIZATION =================================================================================================
+
+// Permission is what a caller needs to use a route. Every route declares one where it is added in setupRoutes.
+type Permission string
+
+const (
+	// Public routes can be used without authentication, e.g. health checks.
+	Public Permission = "public"
+
+	// ReadContacts is needed by routes that read contacts, i.e. GET routes.
+	ReadContacts Permission = "contacts:read"
+
+	// WriteContacts is needed by routes that change contacts, i.e. POST, PUT and DELETE routes.
+	WriteContacts Permission = "contacts:write"
+
+	// AdminContacts is needed by routes that change many contacts at once, like purging or merging contacts.
+	AdminContacts Permission = "contacts:admin"
+)
+
+// Scope is the scope a caller must be granted to have the permission, or "" for Public.
+func (p Permission) Scope() string {
+	if p == Public {
+		return ""
+	}
+
+	return string(p)
+}
+
+// authorize wraps the handler of a route to require the scope of its permission, if authentication is enabled.
+func (s *Server) authorize(permission Permission, handler httprouter.Handle) httprouter.Handle {
+	if permission == "" {
+		panic("Every route must declare a Permission")
+	}
+	if permission == Public || len(s.authenticators) == 0 {
+		return handler
+	}
+
+	scope := permission.Scope()
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if principal := PrincipalFromContext(r.Context()); principal == nil || !principal.HasScope(scope) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="contacts", error="insufficient_scope", scope="%v"`, scope))
+			writeJSONError(w, r, http.StatusForbidden, fmt.Sprintf("Missing scope %v", scope))
+			return
+		}
+
+		handler(w, r, ps)
+	}
+}
+
+// bearerToken returns the token of the request's `Authorization: Bearer` header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
@@ -0,0 +1,216 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/circleci/cci-demo-docker/service"
+	"github.com/circleci/cci-demo-docker/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticAuthenticator accepts a single token.
+type staticAuthenticator struct {
+	token     string
+	principal *service.Principal
+}
+
+func (a staticAuthenticator) Authenticate(ctx context.Context, token string) (*service.Principal, error) {
+	if token != a.token {
+		return nil, service.ErrInvalidToken
+	}
+
+	return a.principal, nil
+}
+
+func Test_Authentication(t *testing.T) {
+	// SETUP: The database is never reached, as the requests are rejected or invalid before that.
+	server := service.NewServer(nil, service.WithAuthentication(staticAuthenticator{
+		token:     "secret",
+		principal: &service.Principal{ID: "test:alice", Name: "alice", Scopes: []string{"contacts:read"}},
+	}))
+	get := func(path string, authorization string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: without a token
+	{
+		w := get("/v1/contacts/%20", "")
+
+		// VERIFY: A JSON 401 asks for a bearer token.
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, `Bearer realm="contacts"`, w.Header().Get("WWW-Authenticate"))
+
+		var body service.Problem
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, http.StatusUnauthorized, body.Status)
+		assert.Equal(t, service.CodeUnauthorized, body.Code)
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: with an invalid token, or another scheme
+	for _, authorization := range []string{"Bearer wrong", "Basic c2VjcmV0"} {
+		w := get("/v1/contacts/%20", authorization)
+
+		// VERIFY:
+		assert.Equal(t, http.StatusUnauthorized, w.Code, authorization)
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: with a valid token
+	{
+		w := get("/v1/contacts/%20", "Bearer secret")
+
+		// VERIFY: The request reaches the handler, which rejects the blank email.
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: routes outside the API
+	{
+		w := get("/healthz", "")
+
+		// VERIFY: They are public.
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+}
+
+func Test_APIKeys(t *testing.T) {
+	// SETUP:
+	env := test.SetupEnv(t)
+	defer env.Close()
+	env.SetupContact("alice@example.xyz", "Alice Zulu")
+	ctx := context.Background()
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: authenticating with a key
+	{
+		key, apiKey, err := env.DB.CreateAPIKey(ctx, service.DefaultAccountID, "billing", test.AllScopes, nil)
+		require.NoError(t, err)
+		client := service.NewClient(env.HttpServer.URL, service.WithAPIKey(key))
+
+		_, err = client.GetContactByEmail("alice@example.xyz")
+
+		// VERIFY: The request succeeds, and the key's use is recorded. Only a hash of the key is stored.
+		require.NoError(t, err)
+		apiKeys, err := env.DB.ListAPIKeys(ctx)
+		require.NoError(t, err)
+		var listed *service.APIKey
+		for _, k := range apiKeys {
+			if k.Prefix == apiKey.Prefix {
+				listed = k
+			}
+		}
+		require.NotNil(t, listed)
+		assert.Equal(t, "billing", listed.Name)
+		assert.Equal(t, test.AllScopes, listed.Scopes)
+		assert.NotNil(t, listed.LastUsedAt)
+
+		var stored, plain int
+		err = env.DB.Read(service.WithAccount(ctx, service.DefaultAccountID), func(tx *service.Transaction) {
+			require.NoError(t, tx.QueryRow("SELECT count(*) FROM api_keys WHERE prefix = $1", apiKey.Prefix).Scan(&stored))
+			require.NoError(t, tx.QueryRow("SELECT count(*) FROM api_keys WHERE hash = $1", []byte(key)).Scan(&plain))
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1, stored)
+		assert.Zero(t, plain)
+
+		// TEST: after revoking the key
+		require.NoError(t, env.DB.RevokeAPIKey(ctx, apiKey.Prefix))
+		_, err = client.GetContactByEmail("alice@example.xyz")
+
+		// VERIFY: The key is rejected, and can't be revoked twice.
+		require.IsType(t, service.ErrorResponse{}, err)
+		assert.Equal(t, http.StatusUnauthorized, err.(service.ErrorResponse).StatusCode)
+		assert.Equal(t, service.ErrAPIKeyNotFound, env.DB.RevokeAPIKey(ctx, apiKey.Prefix))
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: authenticating with an expired key
+	{
+		expired := time.Now().Add(-time.Minute)
+		key, _, err := env.DB.CreateAPIKey(ctx, service.DefaultAccountID, "expired", test.AllScopes, &expired)
+		require.NoError(t, err)
+
+		_, err = service.NewClient(env.HttpServer.URL, service.WithAPIKey(key)).GetContactByEmail("alice@example.xyz")
+
+		// VERIFY:
+		require.IsType(t, service.ErrorResponse{}, err)
+		assert.Equal(t, http.StatusUnauthorized, err.(service.ErrorResponse).StatusCode)
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: authenticating with a key that was never created
+	{
+		key, _, _ := service.GenerateAPIKey()
+
+		_, err := service.NewClient(env.HttpServer.URL, service.WithAPIKey(key)).GetContactByEmail("alice@example.xyz")
+
+		// VERIFY:
+		require.IsType(t, service.ErrorResponse{}, err)
+		assert.Equal(t, http.StatusUnauthorized, err.(service.ErrorResponse).StatusCode)
+	}
+}
+
+func Test_RoutesDeclarePermissions(t *testing.T) {
+	server := service.NewServer(nil)
+
+	for _, route := range server.Routes() {
+		name := route.Method + " " + route.Path
+		require.NotEmpty(t, route.Permission, "%v doesn't declare a permission", name)
+
+		if route.Permission == service.Public {
+			assert.NotContains(t, route.Path, "contacts", "%v must not be public", name)
+			continue
+		}
+
+		switch route.Method {
+		case http.MethodGet:
+			assert.Equal(t, service.ReadContacts, route.Permission, name)
+		case http.MethodPost, http.MethodPut, http.MethodDelete:
+			assert.Contains(t, []service.Permission{service.WriteContacts, service.AdminContacts}, route.Permission, name)
+		}
+	}
+}
+
+func Test_Authorization(t *testing.T) {
+	// SETUP: A caller that may only read. The database is never reached.
+	server := service.NewServer(nil, service.WithAuthentication(staticAuthenticator{
+		token:     "reader",
+		principal: &service.Principal{ID: "test:reader", Scopes: []string{"contacts:read"}},
+	}))
+	request := func(method string, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(`{"email":"alice@example.xyz","name":"Alice Zulu"}`))
+		r.Header.Set("Authorization", "Bearer reader")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	// TEST:
+	read := request(http.MethodGet, "/v1/contacts/%20")
+	write := request(http.MethodPost, "/v1/contacts")
+
+	// VERIFY: Reading reaches the handler, which rejects the blank email, and writing is denied naming the scope.
+	assert.Equal(t, http.StatusBadRequest, read.Code)
+
+	require.Equal(t, http.StatusForbidden, write.Code)
+	assert.Contains(t, write.Header().Get("WWW-Authenticate"), `scope="contacts:write"`)
+	var body service.Problem
+	require.NoError(t, json.Unmarshal(write.Body.Bytes(), &body))
+	assert.Equal(t, service.CodeForbidden, body.Code)
+	assert.Equal(t, "Missing scope contacts:write", body.Detail)
+}
@@ -2,12 +2,20 @@ package service
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/circleci/cci-demo-docker/tracing"
 )
 
 // Client defines the interface exposed by our API.
@@ -16,22 +24,134 @@ type Client interface {
 	GetContactByEmail(email string) (*Contact, error)
 }
 
-// ErrorResponse is returned by our service when an error occurs.
+// ErrorResponse is returned by our service when an error occurs, to clients that prefer application/json to Problems.
+// DefaultClient returns errors from the service as ErrorResponses whichever it gets, and they match the sentinel errors
+// for their code with errors.Is, e.g. errors.Is(err, ErrNotFound). Error responses without a body describing them, e.g.
+// from a proxy, are returned as *ErrorResponse instead, as they always have been.
 type ErrorResponse struct {
 	StatusCode int    `json:"status_code"`
 	Message    string `json:"message"`
+	// RequestID identifies the request in the service's logs.
+	RequestID string `json:"request_id,omitempty"`
+	// IncidentID identifies an unexpected error in the service's logs, if it was one.
+	IncidentID string `json:"incident_id,omitempty"`
+	// RetryAfter is how long the service asked to wait before retrying, e.g. when rate limited, or 0 if it didn't.
+	RetryAfter time.Duration `json:"-"`
+	// Code is the code of the Problem, or the code for the status code if the service didn't send one.
+	Code string `json:"-"`
+	// Errors are the fields of the request body at fault, if the service named them.
+	Errors []FieldError `json:"-"`
 }
 
 func (e ErrorResponse) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("%v: %v (request %v)", e.StatusCode, e.Message, e.RequestID)
+	}
+
 	return fmt.Sprintf("%v: %v", e.StatusCode, e.Message)
 }
 
+// Is reports whether target is the sentinel error for the response's code. Every 400 error is also ErrInvalidRequest.
+func (e ErrorResponse) Is(target error) bool {
+	code := e.Code
+	if code == "" {
+		code = codeForStatus(e.StatusCode)
+	}
+
+	return codeErrors[code] == target || (target == ErrInvalidRequest && e.StatusCode == http.StatusBadRequest)
+}
+
+// Sentinel errors matching the ErrorResponses with each code, with errors.Is.
+var (
+	ErrInvalidRequest       = errors.New("Invalid request")
+	ErrInvalidJSON          = errors.New("Invalid JSON")
+	ErrInvalidField         = errors.New("Invalid field")
+	ErrInvalidEmail         = errors.New("Invalid email")
+	ErrUnauthorized         = errors.New("Unauthorized")
+	ErrForbidden            = errors.New("Forbidden")
+	ErrNotFound             = errors.New("Not found")
+	ErrMethodNotAllowed     = errors.New("Method not allowed")
+	ErrBodyTooLarge         = errors.New("Body too large")
+	ErrUnsupportedMediaType = errors.New("Unsupported media type")
+	ErrRateLimited          = errors.New("Rate limited")
+	ErrInternal             = errors.New("Internal error")
+	ErrUnavailable          = errors.New("Unavailable")
+)
+
+var codeErrors = map[string]error{
+	CodeInvalidRequest:       ErrInvalidRequest,
+	CodeInvalidJSON:          ErrInvalidJSON,
+	CodeInvalidField:         ErrInvalidField,
+	CodeInvalidEmail:         ErrInvalidEmail,
+	CodeUnauthorized:         ErrUnauthorized,
+	CodeForbidden:            ErrForbidden,
+	CodeNotFound:             ErrNotFound,
+	CodeMethodNotAllowed:     ErrMethodNotAllowed,
+	CodeBodyTooLarge:         ErrBodyTooLarge,
+	CodeUnsupportedMediaType: ErrUnsupportedMediaType,
+	CodeRateLimited:          ErrRateLimited,
+	CodeInternal:             ErrInternal,
+	CodeUnavailable:          ErrUnavailable,
+}
+
 // NewClient creates a Client that accesses a service at the given base URL.
-func NewClient(baseURL string) Client {
-	httpClient := http.DefaultClient
-	return &DefaultClient{
-		http:    httpClient,
-		BaseURL: baseURL,
+func NewClient(baseURL string, options ...ClientOption) Client {
+	client := &DefaultClient{
+		http:       http.DefaultClient,
+		BaseURL:    baseURL,
+		APIVersion: DefaultAPIVersion,
+		maxRetries: DefaultMaxRetries,
+		maxWait:    DefaultMaxRetryWait,
+	}
+
+	for _, option := range options {
+		option(client)
+	}
+
+	return client
+}
+
+// ClientOption configures a Client created by NewClient.
+type ClientOption func(*DefaultClient)
+
+// WithAPIVersion makes the Client use the given version of the API, e.g. "v2". An empty version uses the deprecated
+// unversioned paths.
+func WithAPIVersion(version string) ClientOption {
+	return func(c *DefaultClient) {
+		c.APIVersion = version
+	}
+}
+
+// WithAPIKey makes the Client authenticate with the given API key, or any other bearer token.
+func WithAPIKey(key string) ClientOption {
+	return func(c *DefaultClient) {
+		c.apiKey = key
+	}
+}
+
+// WithTLSConfig makes the Client connect using the given TLS configuration, for example to trust a private CA or to
+// present a client certificate. See LoadClientTLSConfig.
+func WithTLSConfig(config *tls.Config) ClientOption {
+	return func(c *DefaultClient) {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = config
+		c.http = &http.Client{Transport: transport}
+	}
+}
+
+// Defaults of WithRetries.
+const (
+	DefaultMaxRetries   = 2
+	DefaultMaxRetryWait = 30 * time.Second
+)
+
+// WithRetries sets how many times a request is retried when the service responds 429 Too Many Requests or 503 Service
+// Unavailable with a Retry-After header, waiting as long as it asks. Requests are not retried if the service asks to
+// wait longer than maxWait. Zero maxRetries disables retries.
+func WithRetries(maxRetries int, maxWait time.Duration) ClientOption {
+	return func(c *DefaultClient) {
+		c.maxRetries = maxRetries
+		c.maxWait = maxWait
 	}
 }
 
@@ -39,23 +159,113 @@ func NewClient(baseURL string) Client {
 
 // DefaultClient provides an implementation of the Client interface.
 type DefaultClient struct {
-	http    *http.Client
-	BaseURL string
+	http       *http.Client
+	ctx        context.Context
+	BaseURL    string
+	APIVersion string
+	maxRetries int
+	maxWait    time.Duration
+	apiKey     string
 }
 
-// performRequestMethod constructs a request and uses `performRequest` to execute it.
+// WithContext returns a copy of the client whose requests use ctx. Requests are canceled when ctx is done, and are
+// part of the trace and request in ctx, which are sent in the traceparent and X-Request-ID headers.
+func (c *DefaultClient) WithContext(ctx context.Context) *DefaultClient {
+	copied := *c
+	copied.ctx = ctx
+	return &copied
+}
+
+// performRequestMethod constructs a request and uses `performRequest` to execute it, retrying while the service asks
+// to, within the limits set by WithRetries.
 func (c *DefaultClient) performRequestMethod(method string, path string, headers map[string]string, data interface{}, response interface{}) error {
+	for attempt := 0; ; attempt++ {
+		req, err := c.newRequest(method, path, headers, data)
+		if err != nil {
+			return err
+		}
 
-	req, err := c.newRequest(method, path, headers, data)
-	if err != nil {
-		return err
+		err = c.performRequest(req, response)
+		errResponse, ok := asErrorResponse(err)
+		if !ok || attempt >= c.maxRetries || !retryable(errResponse, c.maxWait) {
+			return err
+		}
+
+		timer := time.NewTimer(errResponse.RetryAfter)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return err
+		}
 	}
+}
+
+// asErrorResponse returns the ErrorResponse that err is, whether it is one or points to one.
+func asErrorResponse(err error) (ErrorResponse, bool) {
+	switch e := err.(type) {
+	case ErrorResponse:
+		return e, true
+	case *ErrorResponse:
+		return *e, true
+	}
+
+	return ErrorResponse{}, false
+}
 
-	return c.performRequest(req, response)
+// retryable reports whether the service asked to retry the request after at most maxWait.
+func retryable(e ErrorResponse, maxWait time.Duration) bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return e.RetryAfter > 0 && e.RetryAfter <= maxWait
+	}
+
+	return false
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of seconds or an HTTP date. It returns 0 if
+// the header is missing or invalid.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+
+	return 0
 }
 
 // performRequest executes the given request, and uses `response` to parse the JSON response.
-func (c *DefaultClient) performRequest(req *http.Request, response interface{}) error {
+func (c *DefaultClient) performRequest(req *http.Request, response interface{}) (err error) {
+	ctx, span := tracing.Start(
+		req.Context(),
+		"HTTP "+req.Method,
+		tracing.KindClient,
+		tracing.String("http.request.method", req.Method),
+		tracing.String("url.full", req.URL.String()),
+	)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	req = req.WithContext(ctx)
+	tracing.Inject(ctx, req.Header)
+	if id := RequestID(ctx); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+
 	// perform the request
 	httpResponse, err := c.http.Do(req)
 	if err != nil {
@@ -63,6 +273,7 @@ func (c *DefaultClient) performRequest(req *http.Request, response interface{})
 	}
 
 	defer httpResponse.Body.Close()
+	span.SetAttributes(tracing.Int("http.response.status_code", httpResponse.StatusCode))
 
 	// read the response
 	var responseBody []byte
@@ -71,19 +282,18 @@ func (c *DefaultClient) performRequest(req *http.Request, response interface{})
 	}
 
 	if httpResponse.StatusCode >= 400 {
-		contentTypeHeader := httpResponse.Header["Content-Type"]
-		if len(contentTypeHeader) != 0 && contentTypeHeader[0] == "application/json" {
-			var errResponse ErrorResponse
-			err := json.Unmarshal(responseBody, &errResponse)
-			if err == nil {
-				return errResponse
-			}
+		errResponse, described := parseErrorResponse(httpResponse, responseBody)
+		if errResponse.RequestID == "" {
+			errResponse.RequestID = httpResponse.Header.Get(RequestIDHeader)
 		}
-
-		return &ErrorResponse{
-			StatusCode: httpResponse.StatusCode,
-			Message:    httpResponse.Status,
+		if errResponse.Code == "" {
+			errResponse.Code = codeForStatus(errResponse.StatusCode)
+		}
+		errResponse.RetryAfter = parseRetryAfter(httpResponse.Header.Get("Retry-After"))
+		if !described {
+			return &errResponse
 		}
+		return errResponse
 	}
 
 	// map the response to an object value
@@ -94,6 +304,39 @@ func (c *DefaultClient) performRequest(req *http.Request, response interface{})
 	return nil
 }
 
+// parseErrorResponse parses the body of an error response, which is a Problem or an ErrorResponse. Responses with any
+// other body, e.g. from a proxy, are described by their status, and it returns false.
+func parseErrorResponse(httpResponse *http.Response, body []byte) (ErrorResponse, bool) {
+	mediaType, _, _ := mime.ParseMediaType(httpResponse.Header.Get("Content-Type"))
+	switch mediaType {
+	case ProblemContentType:
+		var problem Problem
+		if err := json.Unmarshal(body, &problem); err == nil {
+			message := problem.Detail
+			if message == "" {
+				message = problem.Title
+			}
+
+			return ErrorResponse{
+				StatusCode: httpResponse.StatusCode,
+				Message:    message,
+				RequestID:  problem.RequestID,
+				IncidentID: problem.IncidentID,
+				Code:       problem.Code,
+				Errors:     problem.Errors,
+			}, true
+		}
+
+	case "application/json":
+		var errResponse ErrorResponse
+		if err := json.Unmarshal(body, &errResponse); err == nil {
+			return errResponse, true
+		}
+	}
+
+	return ErrorResponse{StatusCode: httpResponse.StatusCode, Message: httpResponse.Status}, false
+}
+
 // newRequest builds a new request using the given parameters.
 func (c *DefaultClient) newRequest(method string, path string, headers map[string]string, data interface{}) (*http.Request, error) {
 
@@ -108,8 +351,17 @@ func (c *DefaultClient) newRequest(method string, path string, headers map[strin
 		body = bytes.NewReader(requestJSON)
 	}
 
+	if c.APIVersion != "" {
+		path = "/" + c.APIVersion + path
+	}
+
 	// construct the request
-	req, err := http.NewRequest(method, c.BaseURL+path, body)
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
 	if err != nil {
 		return nil, err
 	}
@@ -117,6 +369,10 @@ func (c *DefaultClient) newRequest(method string, path string, headers map[strin
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
+	req.Header.Set("Accept", "application/json, "+ProblemContentType)
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
 
 	for k, v := range headers {
 		req.Header.Set(k, v)
@@ -125,8 +381,6 @@ func (c *DefaultClient) newRequest(method string, path string, headers map[strin
 	return req, nil
 }
 
-
-
 // ----- Add Contact ---------------------------------------------------------------------------------------------------
 
 type AddContactRequest struct {
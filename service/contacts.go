@@ -1,6 +1,9 @@
 package service
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+)
 
 // Contact describes a contact in our database.
 type Contact struct {
@@ -12,19 +15,38 @@ type Contact struct {
 // ===== ADD CONTACT ===================================================================================================
 
 // AddContact inserts a new contact into the database.
-func (db *Database) AddContact(c Contact) (int, error) {
+func (db *Database) AddContact(ctx context.Context, c Contact) (int, error) {
 	var contactId int
-	err := db.Write(func(tx *Transaction) {
+	err := db.Write(ctx, func(tx *Transaction) {
 		contactId = tx.AddContact(c)
 	})
 
 	return contactId, err
 }
 
-// AddContact inserts a new contact within the transaction.
+// AddContact inserts a new contact into the transaction's account.
 func (tx *Transaction) AddContact(c Contact) int {
 	row := tx.QueryRow(
-		"INSERT INTO contacts (email, name) VALUES ($1, $2) RETURNING id",
+		"INSERT INTO contacts (account_id, email, name) VALUES ($1, $2, $3) RETURNING id",
+		tx.Account(),
+		c.Email,
+		c.Name,
+	)
+
+	var id int
+	if err := row.Scan(&id); err != nil {
+		panic(err)
+	}
+
+	return id
+}
+
+// UpsertContact inserts a new contact into the transaction's account, or updates the account's contact with the same
+// email.
+func (tx *Transaction) UpsertContact(c Contact) int {
+	row := tx.QueryRow(
+		"INSERT INTO contacts (account_id, email, name) VALUES ($1, $2, $3) ON CONFLICT (account_id, email) DO UPDATE SET name = EXCLUDED.name RETURNING id",
+		tx.Account(),
 		c.Email,
 		c.Name,
 	)
@@ -40,19 +62,21 @@ func (tx *Transaction) AddContact(c Contact) int {
 // ===== GET CONTACT ===================================================================================================
 
 // GetContactByEmail reads a Contact from the Database.
-func (db *Database) GetContactByEmail(email string) (*Contact, error) {
+func (db *Database) GetContactByEmail(ctx context.Context, email string) (*Contact, error) {
 	var contact *Contact
-	err := db.Read(func(tx *Transaction) {
+	err := db.Read(ctx, func(tx *Transaction) {
 		contact = tx.GetContactByEmail(email)
 	})
 
 	return contact, err
 }
 
-// GetContactByEmail finds a contact given an email address. `nil` is returned if the Contact doesn't exist in the DB.
+// GetContactByEmail finds a contact of the transaction's account given an email address. `nil` is returned if the
+// Contact doesn't exist in the DB, or belongs to another account.
 func (tx *Transaction) GetContactByEmail(email string) *Contact {
 	row := tx.QueryRow(
-		"SELECT id, email, name FROM contacts WHERE email = $1",
+		"SELECT id, email, name FROM contacts WHERE account_id = $1 AND email = $2",
+		tx.Account(),
 		email,
 	)
 
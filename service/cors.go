@@ -0,0 +1,116 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS allows browsers to call the API from pages served by other origins, e.g. an internal dashboard.
+type CORS struct {
+	// AllowedOrigins are origins like "https://dashboard.example.xyz", or wildcards like "https://*.example.xyz" that
+	// match any subdomain. "*" allows every origin.
+	AllowedOrigins []string
+	// AllowedMethods are the methods browsers may use. DefaultCORSMethods are used when it is empty.
+	AllowedMethods []string
+	// AllowedHeaders are the request headers browsers may send. DefaultCORSHeaders are used when it is empty.
+	AllowedHeaders []string
+	// AllowCredentials lets browsers send cookies and HTTP authentication, and read the responses.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache the response to a preflight request. Browsers choose when it is zero.
+	MaxAge time.Duration
+}
+
+// Defaults for the methods and headers allowed by CORS.
+var (
+	DefaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete}
+	DefaultCORSHeaders = []string{"Authorization", "Content-Type", RequestIDHeader}
+)
+
+// WithCORS answers CORS preflight requests, and adds CORS headers to the responses of requests from allowed origins.
+func WithCORS(cors *CORS) ServerOption {
+	return func(s *Server) {
+		s.cors = cors
+	}
+}
+
+// handle adds the CORS headers for the request, reporting whether it was a preflight request that has been answered.
+// Preflights are answered before routing, as the router would reject their OPTIONS method.
+func (c *CORS) handle(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if origin == "" {
+		return false
+	}
+
+	w.Header().Add("Vary", "Origin")
+	if preflight {
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+	}
+
+	if c.allowsOrigin(origin) {
+		if c.allowsAnyOrigin() && !c.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if c.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if preflight {
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(orDefault(c.AllowedMethods, DefaultCORSMethods), ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(orDefault(c.AllowedHeaders, DefaultCORSHeaders), ", "))
+			if c.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+			}
+		}
+	}
+
+	// Preflights from origins that aren't allowed are answered without CORS headers, so that the browser blocks the
+	// request that would follow.
+	if preflight {
+		w.WriteHeader(http.StatusNoContent)
+	}
+	return preflight
+}
+
+func (c *CORS) allowsAnyOrigin() bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *CORS) allowsOrigin(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+
+		// A wildcard like https://*.example.xyz matches subdomains, but not https://example.xyz itself.
+		if prefix, suffix, ok := strings.Cut(allowed, "*"); ok {
+			if len(origin) > len(prefix)+len(suffix) &&
+				strings.HasPrefix(strings.ToLower(origin), strings.ToLower(prefix)) &&
+				strings.HasSuffix(strings.ToLower(origin), strings.ToLower(suffix)) &&
+				!strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], "/:") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func orDefault(values []string, defaults []string) []string {
+	if len(values) == 0 {
+		return defaults
+	}
+
+	return values
+}
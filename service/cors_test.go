@@ -0,0 +1,97 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/circleci/cci-demo-docker/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CORS(t *testing.T) {
+	// SETUP: A server requiring authentication, which preflights don't have. The database is never reached.
+	server := service.NewServer(
+		nil,
+		service.WithAuthentication(staticAuthenticator{token: "secret"}),
+		service.WithCORS(&service.CORS{
+			AllowedOrigins:   []string{"https://dashboard.example.xyz", "https://*.internal.example.xyz"},
+			AllowCredentials: true,
+			MaxAge:           10 * time.Minute,
+		}),
+	)
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodOptions, "/v1/contacts", nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		r.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: preflights from allowed origins, exact and wildcard
+	for _, origin := range []string{"https://dashboard.example.xyz", "https://admin.internal.example.xyz"} {
+		w := preflight(origin)
+
+		// VERIFY: They are answered before routing and authentication.
+		assert.Equal(t, http.StatusNoContent, w.Code, origin)
+		assert.Equal(t, origin, w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), http.MethodPost)
+		assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "Authorization")
+		assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+		assert.Contains(t, w.Header()["Vary"], "Origin")
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: preflights from other origins, including the wildcard's parent domain
+	for _, origin := range []string{"https://evil.example.xyz", "https://internal.example.xyz", "http://dashboard.example.xyz"} {
+		w := preflight(origin)
+
+		// VERIFY: They are answered without CORS headers, so the browser blocks the request.
+		assert.Equal(t, http.StatusNoContent, w.Code, origin)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"), origin)
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: a request from an allowed origin
+	{
+		r := httptest.NewRequest(http.MethodGet, "/v1/contacts/%20", nil)
+		r.Header.Set("Origin", "https://dashboard.example.xyz")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+
+		// VERIFY: The browser may read the response, even an error.
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "https://dashboard.example.xyz", w.Header().Get("Access-Control-Allow-Origin"))
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: an OPTIONS request that isn't a preflight
+	{
+		r := httptest.NewRequest(http.MethodOptions, "/v1/contacts", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+
+		// VERIFY: It is answered by the router, listing the route's methods.
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Allow"), http.MethodPost)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+func Test_CORSAnyOrigin(t *testing.T) {
+	server := service.NewServer(nil, service.WithCORS(&service.CORS{AllowedOrigins: []string{"*"}}))
+	r := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	r.Header.Set("Origin", "https://anywhere.example.xyz")
+	w := httptest.NewRecorder()
+
+	server.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+}
@@ -1,58 +1,208 @@
 package service
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/circleci/cci-demo-docker/tracing"
 )
 
 // Database wraps our SQL database. Defining our own type allows us to define helper functions on the Database.
 type Database struct {
 	DB *sql.DB
+
+	// Hooks are notified of every statement and transaction run through Read and Write.
+	Hooks []Hook
+
+	// Logger defaults to the default logger when nil. The ID of the request a transaction was started for is added to
+	// every record.
+	Logger *slog.Logger
 }
 
-func (db *Database) Close() {
-	db.DB.Close()
+// Close closes the underlying connection pool, waiting for queries that have already started to finish.
+func (db *Database) Close() error {
+	return db.DB.Close()
 }
 
 // ===== TRANSACTIONS ==================================================================================================
 
 // Transaction wraps a SQL transaction. Defining our own type allows functions to be defined on the Transaction.
+// Queries on the data of an account, like contacts, are scoped to the account of the context the transaction was
+// started with, see WithAccount.
 type Transaction struct {
 	*sql.Tx
-	db *Database
+	db        *Database
+	ctx       context.Context
+	accountID int
 }
 
 type TransactionFunc func(*Transaction)
 
-func (db *Database) begin() (*Transaction, error) {
-	tx, err := db.DB.Begin()
+func (db *Database) begin(ctx context.Context) (*Transaction, error) {
+	start := time.Now()
+	tx, err := db.DB.BeginTx(ctx, nil)
+	db.afterTx(TxEvent{Context: ctx, Op: TxBegin, Duration: time.Since(start), Err: err})
 	if err != nil {
 		return nil, err
 	}
 
-	return &Transaction{tx, db}, nil
+	transaction := &Transaction{Tx: tx, db: db, ctx: ctx}
+	if accountID, ok := AccountFromContext(ctx); ok {
+		if err := transaction.scope(accountID); err != nil {
+			transaction.logRollbackError(transaction.Rollback())
+			return nil, err
+		}
+	}
+
+	return transaction, nil
+}
+
+// Context returns the context the transaction was started with, e.g. the context of the HTTP request being handled.
+func (tx *Transaction) Context() context.Context {
+	return tx.ctx
+}
+
+// logRollbackError logs errors rolling back, which are otherwise ignored. Transactions are already rolled back when
+// their context is canceled, so that isn't logged.
+func (tx *Transaction) logRollbackError(err error) {
+	if err != nil && !errors.Is(err, sql.ErrTxDone) {
+		newLogger(tx.db.Logger).ErrorContext(tx.ctx, "Unable to roll back transaction", "error", err.Error())
+	}
+}
+
+// startStatement starts a span for a statement, named after its operation, e.g. SELECT. Arguments aren't recorded, as
+// they may hold personal data.
+func (tx *Transaction) startStatement(query string) (context.Context, *tracing.Span) {
+	operation := "SQL"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		operation = strings.ToUpper(fields[0])
+	}
+
+	return tracing.Start(
+		tx.ctx,
+		operation,
+		tracing.KindClient,
+		tracing.String("db.system", "postgresql"),
+		tracing.String("db.operation", operation),
+		tracing.String("db.statement", query),
+	)
+}
+
+// Exec executes a statement that doesn't return rows, notifying the Database's hooks.
+func (tx *Transaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := tx.startStatement(query)
+	defer span.End()
+
+	start := time.Now()
+	result, err := tx.Tx.ExecContext(ctx, query, args...)
+	span.SetError(err)
+
+	rowsAffected := int64(-1)
+	if err == nil {
+		if n, rowsErr := result.RowsAffected(); rowsErr == nil {
+			rowsAffected = n
+		}
+	}
+	tx.db.afterQuery(QueryEvent{
+		Context:      ctx,
+		SQL:          query,
+		Args:         RedactArgs(args),
+		Duration:     time.Since(start),
+		RowsAffected: rowsAffected,
+		Err:          err,
+	})
+
+	return result, err
+}
+
+// Query executes a statement that returns rows, notifying the Database's hooks.
+func (tx *Transaction) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := tx.startStatement(query)
+	defer span.End()
+
+	start := time.Now()
+	rows, err := tx.Tx.QueryContext(ctx, query, args...)
+	span.SetError(err)
+	tx.db.afterQuery(QueryEvent{
+		Context:      ctx,
+		SQL:          query,
+		Args:         RedactArgs(args),
+		Duration:     time.Since(start),
+		RowsAffected: -1,
+		Err:          err,
+	})
+
+	return rows, err
+}
+
+// QueryRow executes a statement that returns at most one row, notifying the Database's hooks. sql.ErrNoRows is only
+// reported by Scan, so it is never included in the hook's QueryEvent.
+func (tx *Transaction) QueryRow(query string, args ...interface{}) *sql.Row {
+	ctx, span := tx.startStatement(query)
+	defer span.End()
+
+	start := time.Now()
+	row := tx.Tx.QueryRowContext(ctx, query, args...)
+	span.SetError(row.Err())
+	tx.db.afterQuery(QueryEvent{
+		Context:      ctx,
+		SQL:          query,
+		Args:         RedactArgs(args),
+		Duration:     time.Since(start),
+		RowsAffected: -1,
+		Err:          row.Err(),
+	})
+
+	return row
+}
+
+// Commit commits the transaction, notifying the Database's hooks.
+func (tx *Transaction) Commit() error {
+	start := time.Now()
+	err := tx.Tx.Commit()
+	tx.db.afterTx(TxEvent{Context: tx.ctx, Op: TxCommit, Duration: time.Since(start), Err: err})
+	return err
+}
+
+// Rollback aborts the transaction, notifying the Database's hooks.
+func (tx *Transaction) Rollback() error {
+	start := time.Now()
+	err := tx.Tx.Rollback()
+	tx.db.afterTx(TxEvent{Context: tx.ctx, Op: TxRollback, Duration: time.Since(start), Err: err})
+	return err
 }
 
 // Read begins a read-only transaction and passes it to the given function. The transaction will be rolled back after
-// the function returns. Any panics will be handled, and returned as an error.
-func (db *Database) Read(reader TransactionFunc) (err error) {
-	tx, err := db.begin()
+// the function returns. Any panics will be handled, and returned as an error. The transaction is aborted if ctx is
+// canceled.
+func (db *Database) Read(ctx context.Context, reader TransactionFunc) (err error) {
+	start := time.Now()
+	ctx, span := startTransaction(ctx, "read")
+	tx, err := db.begin(ctx)
 	if err != nil {
+		span.SetError(err)
+		span.End()
 		return err
 	}
 
+	outcome := outcomeRollback
+
 	// A read should always rollback the transaction
 	defer func() {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			// Ignore errors rolling back
-			log.Println(rollbackErr.Error())
-		}
+		tx.logRollbackError(tx.Rollback())
+		dbTransactionDuration.With("read", outcome).ObserveDuration(time.Since(start))
+		endTransaction(span, outcome, err)
 	}()
 
 	// recover any panics during the transaction, and return it as an error to the caller
 	defer func() {
 		if r := recover(); r != nil {
+			outcome = outcomePanic
 			var ok bool
 			err, ok = r.(error)
 			if !ok {
@@ -72,10 +222,15 @@ func (db *Database) Read(reader TransactionFunc) (err error) {
 }
 
 // Write begins a transaction and passes it to the given function. The transaction will be committed when the function
-// returns. If the function panics, the transaction is rolled back, and the error provided to panic is returned.
-func (db *Database) Write(writer TransactionFunc) (err error) {
-	tx, err := db.begin()
+// returns. If the function panics, the transaction is rolled back, and the error provided to panic is returned. The
+// transaction is aborted if ctx is canceled.
+func (db *Database) Write(ctx context.Context, writer TransactionFunc) (err error) {
+	start := time.Now()
+	ctx, span := startTransaction(ctx, "write")
+	tx, err := db.begin(ctx)
 	if err != nil {
+		span.SetError(err)
+		span.End()
 		return err
 	}
 
@@ -83,16 +238,19 @@ func (db *Database) Write(writer TransactionFunc) (err error) {
 
 	// write operations commit or rollback the transaction
 	defer func() {
+		outcome := outcomeCommit
 		if didPanic {
-			if rollbackErr := tx.Rollback(); rollbackErr != nil {
-				// Ignore errors rolling back
-				log.Println(rollbackErr.Error())
-			}
+			outcome = outcomePanic
+			tx.logRollbackError(tx.Rollback())
 		} else {
 			if commitErr := tx.Commit(); commitErr != nil {
+				// Postgres rolls back transactions that fail to commit
+				outcome = outcomeRollback
 				err = commitErr
 			}
 		}
+		dbTransactionDuration.With("write", outcome).ObserveDuration(time.Since(start))
+		endTransaction(span, outcome, err)
 	}()
 
 	// recover any panics during the transaction, and return it as an error to the caller
@@ -112,3 +270,20 @@ func (db *Database) Write(writer TransactionFunc) (err error) {
 
 	return err
 }
+
+// startTransaction starts the span of a Read or Write transaction, which its statements are children of.
+func startTransaction(ctx context.Context, mode string) (context.Context, *tracing.Span) {
+	return tracing.Start(
+		ctx,
+		"db.transaction",
+		tracing.KindInternal,
+		tracing.String("db.system", "postgresql"),
+		tracing.String("db.transaction.mode", mode),
+	)
+}
+
+func endTransaction(span *tracing.Span, outcome string, err error) {
+	span.SetAttributes(tracing.String("db.transaction.outcome", outcome))
+	span.SetError(err)
+	span.End()
+}
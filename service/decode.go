@@ -0,0 +1,362 @@
+package service
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxBodySize is the largest request body accepted, unless changed with WithMaxBodySize.
+const DefaultMaxBodySize = 1 << 20
+
+// WithMaxBodySize sets the largest request body accepted, in bytes. Larger bodies are rejected with a 413.
+func WithMaxBodySize(n int64) ServerOption {
+	return func(s *Server) {
+		s.maxBodySize = n
+	}
+}
+
+// DecodeError describes why a request body was rejected. Path and Offset locate the problem in the body, when it is
+// within the JSON document.
+type DecodeError struct {
+	StatusCode int
+	// Code is the code of the Problem, e.g. CodeInvalidJSON.
+	Code    string
+	Message string
+	// Path is a JSONPath like $.contacts[2].email, or "" if the problem isn't at a value.
+	Path string
+	// Offset is the number of bytes of the body before the problem.
+	Offset int64
+}
+
+func (e *DecodeError) Error() string {
+	return e.Message
+}
+
+func (e *DecodeError) HttpStatusCode() int {
+	return e.StatusCode
+}
+
+func (e *DecodeError) HttpStatusMessage() string {
+	return e.Message
+}
+
+func (e *DecodeError) ProblemCode() string {
+	return e.Code
+}
+
+// ProblemErrors names the field at fault, if the problem is with one field.
+func (e *DecodeError) ProblemErrors() []FieldError {
+	if e.Code != CodeInvalidField {
+		return nil
+	}
+
+	return []FieldError{{Field: e.Path, Detail: e.Message}}
+}
+
+// decodeJSON decodes the JSON body of a request into v, which should be a pointer to a struct. The body is rejected if
+// it is larger than the server's maximum body size, isn't application/json, has fields that v doesn't, or has anything
+// after the JSON value.
+func (s *Server) decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) *DecodeError {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return &DecodeError{StatusCode: http.StatusUnsupportedMediaType, Code: CodeUnsupportedMediaType, Message: "Content-Type must be application/json"}
+	}
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBodySize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return &DecodeError{
+			StatusCode: http.StatusRequestEntityTooLarge,
+			Code:       CodeBodyTooLarge,
+			Message:    fmt.Sprintf("Request body must not be larger than %v bytes", tooLarge.Limit),
+		}
+	} else if err != nil {
+		return &DecodeError{StatusCode: http.StatusBadRequest, Code: CodeInvalidRequest, Message: fmt.Sprintf("Unable to read request body: %v", err)}
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return &DecodeError{StatusCode: http.StatusBadRequest, Code: CodeInvalidJSON, Message: "A JSON request body is required"}
+	}
+
+	// The body is checked against the type of v first, as encoding/json doesn't report where unknown fields are.
+	if err := checkJSON(data, reflect.TypeOf(v)); err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		decodeErr := &DecodeError{StatusCode: http.StatusBadRequest, Code: CodeInvalidJSON, Message: fmt.Sprintf("Invalid JSON: %v", err)}
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			decodeErr.Code = CodeInvalidField
+			decodeErr.Path = "$." + typeErr.Field
+			decodeErr.Offset = typeErr.Offset
+			decodeErr.Message = fmt.Sprintf("Invalid %v at %v (offset %v)", typeErr.Value, decodeErr.Path, decodeErr.Offset)
+		}
+		return decodeErr
+	}
+
+	return nil
+}
+
+// jsonChecker walks the tokens of a JSON document alongside the Go type it will be decoded into.
+type jsonChecker struct {
+	data    []byte
+	decoder *json.Decoder
+}
+
+// checkJSON returns an error locating the first syntax error, unknown field, value of the wrong kind, or trailing data
+// in data, when decoded into a value of type t.
+func checkJSON(data []byte, t reflect.Type) *DecodeError {
+	c := &jsonChecker{data: data, decoder: json.NewDecoder(bytes.NewReader(data))}
+	c.decoder.UseNumber()
+
+	if err := c.value("$", t); err != nil {
+		return err
+	}
+
+	offset := c.offset()
+	if _, err := c.decoder.Token(); err != io.EOF {
+		return &DecodeError{
+			StatusCode: http.StatusBadRequest,
+			Code:       CodeInvalidJSON,
+			Message:    fmt.Sprintf("Unexpected data after the JSON value (offset %v)", offset),
+			Offset:     offset,
+		}
+	}
+
+	return nil
+}
+
+// value checks the next value, at path, against t. Types that decode themselves, and interfaces, aren't checked.
+func (c *jsonChecker) value(path string, t reflect.Type) *DecodeError {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t != nil && (reflect.PointerTo(t).Implements(reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()) ||
+		reflect.PointerTo(t).Implements(reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()) ||
+		t.Kind() == reflect.Interface) {
+		t = nil
+	}
+
+	offset := c.offset()
+	token, err := c.decoder.Token()
+	if err != nil {
+		return c.syntaxError(path, offset, err)
+	}
+
+	expected := ""
+	switch token := token.(type) {
+	case json.Delim:
+		switch token {
+		case '{':
+			if t != nil && t.Kind() != reflect.Struct && t.Kind() != reflect.Map {
+				expected = kindName(t)
+				break
+			}
+			return c.object(path, t)
+		case '[':
+			if t != nil && t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+				expected = kindName(t)
+				break
+			}
+			var elem reflect.Type
+			if t != nil {
+				elem = t.Elem()
+			}
+			return c.array(path, elem)
+		default:
+			return c.syntaxError(path, offset, fmt.Errorf("unexpected %q", token))
+		}
+	case string:
+		if t != nil && t.Kind() != reflect.String {
+			expected = kindName(t)
+		}
+	case json.Number:
+		if t != nil && !isNumber(t) {
+			expected = kindName(t)
+		}
+	case bool:
+		if t != nil && t.Kind() != reflect.Bool {
+			expected = kindName(t)
+		}
+	}
+
+	if expected != "" {
+		return &DecodeError{
+			StatusCode: http.StatusBadRequest,
+			Code:       CodeInvalidField,
+			Message:    fmt.Sprintf("Expected %v at %v (offset %v)", expected, path, offset),
+			Path:       path,
+			Offset:     offset,
+		}
+	}
+	return nil
+}
+
+func (c *jsonChecker) object(path string, t reflect.Type) *DecodeError {
+	for c.decoder.More() {
+		offset := c.offset()
+		token, err := c.decoder.Token()
+		if err != nil {
+			return c.syntaxError(path, offset, err)
+		}
+		key, _ := token.(string)
+		keyPath := path + "." + key
+
+		var field reflect.Type
+		if t != nil && t.Kind() == reflect.Map {
+			field = t.Elem()
+		} else if t != nil {
+			var ok bool
+			if field, ok = structField(t, key); !ok {
+				return &DecodeError{
+					StatusCode: http.StatusBadRequest,
+					Code:       CodeInvalidField,
+					Message:    fmt.Sprintf("Unknown field %q at %v (offset %v)", key, keyPath, offset),
+					Path:       keyPath,
+					Offset:     offset,
+				}
+			}
+		}
+
+		if err := c.value(keyPath, field); err != nil {
+			return err
+		}
+	}
+
+	return c.end(path)
+}
+
+func (c *jsonChecker) array(path string, elem reflect.Type) *DecodeError {
+	for i := 0; c.decoder.More(); i++ {
+		if err := c.value(path+"["+strconv.Itoa(i)+"]", elem); err != nil {
+			return err
+		}
+	}
+
+	return c.end(path)
+}
+
+// end reads the closing delimiter of an object or array.
+func (c *jsonChecker) end(path string) *DecodeError {
+	offset := c.offset()
+	if _, err := c.decoder.Token(); err != nil {
+		return c.syntaxError(path, offset, err)
+	}
+
+	return nil
+}
+
+// offset returns the offset of the next token, skipping the whitespace and separators before it.
+func (c *jsonChecker) offset() int64 {
+	offset := c.decoder.InputOffset()
+	for offset < int64(len(c.data)) && strings.IndexByte(" \t\r\n,:", c.data[offset]) >= 0 {
+		offset++
+	}
+
+	return offset
+}
+
+func (c *jsonChecker) syntaxError(path string, offset int64, err error) *DecodeError {
+	// The decoder's token stream describes some errors by the separator before them, so they are described the way
+	// json.Unmarshal would instead.
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		if unmarshalErr := json.Unmarshal(c.data, new(interface{})); errors.As(unmarshalErr, &syntaxErr) {
+			err = unmarshalErr
+		}
+		// The offset is after the invalid character, unless the input ended.
+		offset = syntaxErr.Offset
+		if err.Error() != "unexpected end of JSON input" {
+			offset--
+		}
+	} else if err == io.EOF || err == io.ErrUnexpectedEOF {
+		offset = int64(len(c.data))
+		err = errors.New("unexpected end of JSON input")
+	}
+
+	return &DecodeError{
+		StatusCode: http.StatusBadRequest,
+		Code:       CodeInvalidJSON,
+		Message:    fmt.Sprintf("Invalid JSON at %v (offset %v): %v", path, offset, strings.TrimPrefix(err.Error(), "json: ")),
+		Path:       path,
+		Offset:     offset,
+	}
+}
+
+// structField returns the type of the field of struct t that a JSON key decodes into, matching names the way
+// encoding/json does, case-insensitively.
+func structField(t reflect.Type, key string) (reflect.Type, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" && field.Anonymous {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if fieldType, ok := structField(embedded, key); ok {
+					return fieldType, true
+				}
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		if strings.EqualFold(name, key) {
+			return field.Type, true
+		}
+	}
+
+	return nil, false
+}
+
+// kindName describes the JSON values that decode into t, for errors.
+func kindName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	if isNumber(t) {
+		return "a number"
+	}
+
+	return t.String()
+}
+
+func isNumber(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,105 @@
+package service_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/circleci/cci-demo-docker/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DecodeJSON(t *testing.T) {
+	// SETUP: A server accepting small bodies. Every request is rejected before the database is reached.
+	server := service.NewServer(nil, service.WithMaxBodySize(64))
+	post := func(contentType string, body string) (int, service.Problem) {
+		r := httptest.NewRequest(http.MethodPost, "/v1/contacts", strings.NewReader(body))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+
+		var problem service.Problem
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem), w.Body.String())
+		return w.Code, problem
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: bodies that aren't JSON
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		code, problem := post(contentType, `{"email": "alice@example.xyz"}`)
+
+		// VERIFY:
+		assert.Equal(t, http.StatusUnsupportedMediaType, code, contentType)
+		assert.Equal(t, service.CodeUnsupportedMediaType, problem.Code)
+		assert.Equal(t, "Content-Type must be application/json", problem.Detail)
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: a body larger than the limit
+	{
+		code, problem := post("application/json", `{"email": "alice@example.xyz", "name": "`+strings.Repeat("x", 64)+`"}`)
+
+		// VERIFY:
+		assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+		assert.Equal(t, service.CodeBodyTooLarge, problem.Code)
+		assert.Equal(t, "Request body must not be larger than 64 bytes", problem.Detail)
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: invalid bodies, located by their JSON path and offset
+	invalid := map[string]string{
+		`{"email": "a@example.xyz", "nmae": "Alice"}`: `Unknown field "nmae" at $.nmae (offset 27)`,
+		`{"email": "a@example.xyz", "name": 5}`:       `Expected a string at $.name (offset 35)`,
+		`{"email": ["a@example.xyz"]}`:                `Expected a string at $.email (offset 10)`,
+		`{"email": "a@example.xyz"} {}`:               `Unexpected data after the JSON value (offset 27)`,
+		`{"email": "a@example.xyz",}`:                 `Invalid JSON at $ (offset 26): invalid character '}' looking for beginning of object key string`,
+		`{"email": "a@example.xyz"`:                   `Invalid JSON at $ (offset 25): unexpected end of JSON input`,
+		`[]`:                                          `Expected an object at $ (offset 0)`,
+		` `:                                           `A JSON request body is required`,
+	}
+	for body, expected := range invalid {
+		code, problem := post("application/json; charset=utf-8", body)
+
+		// VERIFY:
+		assert.Equal(t, http.StatusBadRequest, code, body)
+		assert.Equal(t, expected, problem.Detail, body)
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: the codes of invalid bodies
+	{
+		_, syntax := post("application/json", `{"email": "a@example.xyz",}`)
+		_, field := post("application/json", `{"email": "a@example.xyz", "name": 5}`)
+
+		// VERIFY: Problems with a field name it.
+		assert.Equal(t, service.CodeInvalidJSON, syntax.Code)
+		assert.Empty(t, syntax.Errors)
+		assert.Equal(t, service.CodeInvalidField, field.Code)
+		assert.Equal(t, []service.FieldError{{Field: "$.name", Detail: "Expected a string at $.name (offset 35)"}}, field.Errors)
+	}
+}
+
+func Test_DecodeJSONWithRequestValidation(t *testing.T) {
+	// SETUP: A server validating requests against the spec before they reach a handler.
+	server := service.NewServer(nil, service.WithRequestValidation(), service.WithMaxBodySize(16))
+	post := func(contentType string, body string) int {
+		r := httptest.NewRequest(http.MethodPost, "/v1/contacts", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// TEST:
+	unsupported := post("text/plain", `{}`)
+	tooLarge := post("application/json", `{"email": "alice@example.xyz"}`)
+
+	// VERIFY: The spec's validation rejects them the same way as the handler would.
+	assert.Equal(t, http.StatusUnsupportedMediaType, unsupported)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, tooLarge)
+}
@@ -0,0 +1,8 @@
+package service
+
+import "time"
+
+// SetJWKSClock makes the JWKS tell the time with now, so that tests can move it along instead of sleeping.
+func SetJWKSClock(k *JWKS, now func() time.Time) {
+	k.now = now
+}
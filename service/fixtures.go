@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Fixtures declares records to insert into the Database, for seeding demo and QA environments and for tests. Records
+// may be given a `ref`, naming them so that other records, and the caller, can refer to them.
+type Fixtures struct {
+	Accounts []AccountFixture `yaml:"accounts"`
+	Contacts []ContactFixture `yaml:"contacts"`
+}
+
+// AccountFixture declares an Account. If an account with the name already exists, it is used instead.
+type AccountFixture struct {
+	Ref  string `yaml:"ref"`
+	Name string `yaml:"name"`
+}
+
+// ContactFixture declares a Contact. Account is the ref of the account it belongs to, or empty for the default account.
+type ContactFixture struct {
+	Ref     string `yaml:"ref"`
+	Account string `yaml:"account"`
+	Email   string `yaml:"email"`
+	Name    string `yaml:"name"`
+}
+
+// SeededFixtures holds the records created from Fixtures, keyed by their refs.
+type SeededFixtures struct {
+	Accounts map[string]*Account
+	Contacts map[string]*Contact
+}
+
+// ReadFixtures parses a YAML (.yaml, .yml) or JSON (.json) fixtures file. Unknown fields are rejected, to catch typos.
+func ReadFixtures(path string) (*Fixtures, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+	default:
+		return nil, fmt.Errorf("Unsupported fixtures file %v, expected .yaml, .yml or .json", path)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is a subset of YAML, so the YAML parser reads both.
+	var fixtures Fixtures
+	if err := yaml.UnmarshalStrict(content, &fixtures); err != nil {
+		return nil, fmt.Errorf("Unable to parse fixtures %v: %v", path, err)
+	}
+
+	return &fixtures, nil
+}
+
+// validate checks that refs are unique, and that the accounts referred to are declared, before anything is written.
+func (f *Fixtures) validate() error {
+	accountRefs := make(map[string]bool)
+	for i, account := range f.Accounts {
+		if account.Name == "" {
+			return fmt.Errorf("accounts[%v]: name is required", i)
+		}
+		if account.Ref == "" {
+			continue
+		}
+		if accountRefs[account.Ref] {
+			return fmt.Errorf("accounts[%v]: ref %q is used more than once", i, account.Ref)
+		}
+		accountRefs[account.Ref] = true
+	}
+
+	refs := make(map[string]bool)
+	for i, contact := range f.Contacts {
+		if contact.Email == "" {
+			return fmt.Errorf("contacts[%v]: email is required", i)
+		}
+		if contact.Account != "" && !accountRefs[contact.Account] {
+			return fmt.Errorf("contacts[%v]: account %q isn't declared", i, contact.Account)
+		}
+		if contact.Ref == "" {
+			continue
+		}
+		if refs[contact.Ref] {
+			return fmt.Errorf("contacts[%v]: ref %q is used more than once", i, contact.Ref)
+		}
+		refs[contact.Ref] = true
+	}
+
+	return nil
+}
+
+// LoadFixtures inserts the fixtures in a single transaction, so either every record is created or none are. With
+// upsert, existing records are updated instead of failing, so seeding can safely be re-run.
+func (db *Database) LoadFixtures(ctx context.Context, fixtures *Fixtures, upsert bool) (*SeededFixtures, error) {
+	if err := fixtures.validate(); err != nil {
+		return nil, err
+	}
+
+	seeded := &SeededFixtures{Accounts: make(map[string]*Account), Contacts: make(map[string]*Contact)}
+	err := db.Write(ctx, func(tx *Transaction) {
+		for _, fixture := range fixtures.Accounts {
+			account := tx.CreateAccount(fixture.Name)
+			if fixture.Ref != "" {
+				seeded.Accounts[fixture.Ref] = account
+			}
+		}
+
+		for _, fixture := range fixtures.Contacts {
+			if fixture.Account != "" {
+				tx.SetAccount(seeded.Accounts[fixture.Account].Id)
+			} else {
+				tx.SetAccount(DefaultAccountID)
+			}
+
+			contact := Contact{Email: fixture.Email, Name: fixture.Name}
+			if upsert {
+				contact.Id = tx.UpsertContact(contact)
+			} else {
+				contact.Id = tx.AddContact(contact)
+			}
+
+			if fixture.Ref != "" {
+				seeded.Contacts[fixture.Ref] = &contact
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return seeded, nil
+}
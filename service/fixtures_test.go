@@ -0,0 +1,110 @@
+package service_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/circleci/cci-demo-docker/service"
+	"github.com/circleci/cci-demo-docker/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LoadFixtures(t *testing.T) {
+	env := test.SetupEnv(t)
+	defer env.Close()
+
+	// TEST:
+	seeded := env.LoadFixtures("contacts.yaml")
+
+	// VERIFY: Contacts are created and available by ref.
+	require.Contains(t, seeded.Contacts, "alice")
+	assert.True(t, seeded.Contacts["alice"].Id > 0)
+
+	dbContact := env.ReadContactWithEmail("bob@example.xyz")
+	require.NotNil(t, dbContact)
+	assert.Equal(t, seeded.Contacts["bob"].Id, dbContact.Id)
+}
+
+func Test_LoadFixturesUpsert(t *testing.T) {
+	env := test.SetupEnv(t)
+	defer env.Close()
+
+	// SETUP:
+	original := env.SetupContact("alice@example.xyz", "Alice Zulu")
+
+	dir, err := ioutil.TempDir("", "fixtures")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "contacts.json")
+	err = ioutil.WriteFile(path, []byte(`{"contacts": [{"ref": "alice", "email": "alice@example.xyz", "name": "Alice Xray"}]}`), 0644)
+	require.NoError(t, err)
+
+	fixtures, err := service.ReadFixtures(path)
+	require.NoError(t, err)
+
+	// TEST: Inserting an existing contact.
+	_, err = env.DB.LoadFixtures(context.Background(), fixtures, false)
+
+	// VERIFY: Fails, as the contact exists.
+	require.Error(t, err)
+
+	// TEST: Upserting it, twice.
+	_, err = env.DB.LoadFixtures(context.Background(), fixtures, true)
+	require.NoError(t, err)
+	seeded, err := env.DB.LoadFixtures(context.Background(), fixtures, true)
+	require.NoError(t, err)
+
+	// VERIFY: The existing contact is updated in place.
+	assert.Equal(t, original.Id, seeded.Contacts["alice"].Id)
+	assert.Equal(t, "Alice Xray", env.ReadContactWithEmail("alice@example.xyz").Name)
+}
+
+func Test_LoadFixturesRejectsDuplicateRefs(t *testing.T) {
+	env := test.SetupEnv(t)
+	defer env.Close()
+
+	fixtures := &service.Fixtures{Contacts: []service.ContactFixture{
+		{Ref: "alice", Email: "alice@example.xyz"},
+		{Ref: "alice", Email: "alice2@example.xyz"},
+	}}
+
+	_, err := env.DB.LoadFixtures(context.Background(), fixtures, false)
+
+	assert.Error(t, err)
+	assert.Nil(t, env.ReadContactWithEmail("alice@example.xyz"), "Nothing should be written")
+}
+
+func Test_LoadFixturesAccounts(t *testing.T) {
+	env := test.SetupEnv(t)
+	defer env.Close()
+
+	// TEST:
+	seeded := env.LoadFixtures("accounts.yaml")
+
+	// VERIFY: Accounts are created and available by ref, and each has its own contact with the same email.
+	require.Contains(t, seeded.Accounts, "acme")
+	require.Contains(t, seeded.Accounts, "globex")
+
+	acmeAlice := env.ReadAccountContactWithEmail(seeded.Accounts["acme"].Id, "alice@example.xyz")
+	require.NotNil(t, acmeAlice)
+	assert.Equal(t, seeded.Contacts["acme_alice"].Id, acmeAlice.Id)
+	assert.Equal(t, "Alice Xray", env.ReadAccountContactWithEmail(seeded.Accounts["globex"].Id, "alice@example.xyz").Name)
+	assert.Nil(t, env.ReadContactWithEmail("alice@example.xyz"), "The default account has no contacts")
+}
+
+func Test_LoadFixturesRejectsUndeclaredAccounts(t *testing.T) {
+	env := test.SetupEnv(t)
+	defer env.Close()
+
+	fixtures := &service.Fixtures{Contacts: []service.ContactFixture{
+		{Ref: "alice", Account: "acme", Email: "alice@example.xyz"},
+	}}
+
+	_, err := env.DB.LoadFixtures(context.Background(), fixtures, false)
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Readiness tracks the reasons, if any, that the server shouldn't receive traffic. A load balancer polls it through
+// the /readyz route.
+type Readiness struct {
+	mu       sync.Mutex
+	failures map[string]string
+}
+
+// NewReadiness creates a Readiness that is initially ready.
+func NewReadiness() *Readiness {
+	return &Readiness{failures: make(map[string]string)}
+}
+
+// Fail marks the server as not ready because of the named check, until Pass is called for it.
+func (r *Readiness) Fail(check string, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures[check] = reason
+}
+
+// Pass clears a failure previously recorded for the named check.
+func (r *Readiness) Pass(check string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.failures, check)
+}
+
+// Ready reports whether every check passes, and otherwise why not, ordered by check name.
+func (r *Readiness) Ready() (bool, []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reasons := make([]string, 0, len(r.failures))
+	for check, reason := range r.failures {
+		reasons = append(reasons, check+": "+reason)
+	}
+	sort.Strings(reasons)
+
+	return len(reasons) == 0, reasons
+}
+
+// HealthResponse is returned by the health check routes.
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+// Healthz reports that the process is alive, regardless of readiness.
+func (s *Server) Healthz(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeJSON(w, http.StatusOK, &HealthResponse{Status: "ok"})
+}
+
+// Readyz reports whether the server is ready to receive traffic.
+func (s *Server) Readyz(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if ready, reasons := s.Readiness.Ready(); !ready {
+		writeJSONError(w, r, http.StatusServiceUnavailable, strings.Join(reasons, "; "))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &HealthResponse{Status: "ready"})
+}
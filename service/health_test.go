@@ -0,0 +1,41 @@
+package service_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/circleci/cci-demo-docker/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Readyz(t *testing.T) {
+	env := test.SetupEnv(t)
+	defer env.Close()
+
+	// TEST: A ready server.
+	{
+		response, err := http.Get(env.HttpServer.URL + "/readyz")
+
+		// VERIFY:
+		require.NoError(t, err)
+		response.Body.Close()
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	}
+
+	// TEST: A server with a failing check.
+	{
+		env.Server.Readiness.Fail("schema", "drifted")
+		response, err := http.Get(env.HttpServer.URL + "/readyz")
+
+		// VERIFY: Not ready, although still alive.
+		require.NoError(t, err)
+		response.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+
+		response, err = http.Get(env.HttpServer.URL + "/healthz")
+		require.NoError(t, err)
+		response.Body.Close()
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	}
+}
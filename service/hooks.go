@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/circleci/cci-demo-docker/metrics"
+)
+
+// Hook is implemented by types that want to observe the statements and transactions run against a Database. Hooks are
+// called synchronously after each operation completes, so they should be cheap and must be safe for concurrent use.
+type Hook interface {
+	AfterQuery(event QueryEvent)
+	AfterTx(event TxEvent)
+}
+
+// QueryEvent describes a single statement executed within a Transaction.
+type QueryEvent struct {
+	// Context is the context of the Transaction, e.g. carrying the ID of the request it was started for.
+	Context context.Context
+	SQL     string
+	// Args are the statement arguments after being passed through RedactArgs.
+	Args     []interface{}
+	Duration time.Duration
+	// RowsAffected is -1 when it isn't known, e.g. for QueryRow and Query.
+	RowsAffected int64
+	Err          error
+}
+
+// TxOp identifies the transaction operation a TxEvent describes.
+type TxOp int
+
+const (
+	TxBegin TxOp = iota
+	TxCommit
+	TxRollback
+)
+
+func (op TxOp) String() string {
+	switch op {
+	case TxBegin:
+		return "begin"
+	case TxCommit:
+		return "commit"
+	case TxRollback:
+		return "rollback"
+	default:
+		return fmt.Sprintf("TxOp(%d)", int(op))
+	}
+}
+
+// TxEvent describes a transaction being started, committed, or rolled back.
+type TxEvent struct {
+	Context  context.Context
+	Op       TxOp
+	Duration time.Duration
+	Err      error
+}
+
+// redactedArg replaces any argument that could hold personal data, like an email address.
+const redactedArg = "[REDACTED]"
+
+// RedactArgs returns a copy of args that is safe to log. Numbers, booleans, times, and nil are kept as they are useful
+// when debugging and don't identify anyone. Everything else is replaced.
+func RedactArgs(args []interface{}) []interface{} {
+	redacted := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch arg.(type) {
+		case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, time.Time:
+			redacted[i] = arg
+		default:
+			redacted[i] = redactedArg
+		}
+	}
+
+	return redacted
+}
+
+func (db *Database) afterQuery(event QueryEvent) {
+	for _, hook := range db.Hooks {
+		hook.AfterQuery(event)
+	}
+}
+
+func (db *Database) afterTx(event TxEvent) {
+	for _, hook := range db.Hooks {
+		hook.AfterTx(event)
+	}
+}
+
+// ===== SLOW QUERY LOGGER =============================================================================================
+
+// SlowQueryLogger is a Hook that logs every statement that takes at least Threshold to run.
+type SlowQueryLogger struct {
+	Threshold time.Duration
+	// Logger defaults to the default logger when nil.
+	Logger *slog.Logger
+}
+
+func (h *SlowQueryLogger) AfterQuery(event QueryEvent) {
+	if event.Duration < h.Threshold {
+		return
+	}
+
+	ctx := event.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	attrs := []slog.Attr{
+		slog.Duration("duration", event.Duration),
+		slog.String("sql", event.SQL),
+		slog.String("args", fmt.Sprint(event.Args)),
+	}
+	if event.Err != nil {
+		attrs = append(attrs, slog.String("error", event.Err.Error()))
+	}
+	newLogger(h.Logger).LogAttrs(ctx, slog.LevelWarn, "Slow query", attrs...)
+}
+
+func (h *SlowQueryLogger) AfterTx(event TxEvent) {}
+
+// ===== LATENCY HISTOGRAM =============================================================================================
+
+// DefaultLatencyBuckets are the upper bounds used by a LatencyHistogram when none are given.
+var DefaultLatencyBuckets = []time.Duration{
+	1 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+}
+
+// LatencyHistogram is a Hook that records the latency of each distinct SQL statement in a histogram.
+type LatencyHistogram struct {
+	buckets []time.Duration
+
+	mu         sync.Mutex
+	statements map[string]*StatementLatency
+}
+
+// StatementLatency is the recorded latency distribution for one SQL statement. Counts[i] is the number of executions
+// that took at most Buckets[i]; executions slower than the last bucket are only included in Count.
+type StatementLatency struct {
+	SQL     string
+	Count   uint64
+	Errors  uint64
+	Sum     time.Duration
+	Buckets []time.Duration
+	Counts  []uint64
+}
+
+// NewLatencyHistogram creates a LatencyHistogram with the given bucket upper bounds, or DefaultLatencyBuckets.
+func NewLatencyHistogram(buckets ...time.Duration) *LatencyHistogram {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+
+	sorted := append([]time.Duration(nil), buckets...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return &LatencyHistogram{
+		buckets:    sorted,
+		statements: make(map[string]*StatementLatency),
+	}
+}
+
+func (h *LatencyHistogram) AfterQuery(event QueryEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stmt, ok := h.statements[event.SQL]
+	if !ok {
+		stmt = &StatementLatency{
+			SQL:     event.SQL,
+			Buckets: h.buckets,
+			Counts:  make([]uint64, len(h.buckets)),
+		}
+		h.statements[event.SQL] = stmt
+	}
+
+	stmt.Count++
+	stmt.Sum += event.Duration
+	if event.Err != nil {
+		stmt.Errors++
+	}
+	for i, upperBound := range h.buckets {
+		if event.Duration <= upperBound {
+			stmt.Counts[i]++
+		}
+	}
+}
+
+func (h *LatencyHistogram) AfterTx(event TxEvent) {}
+
+// Snapshot returns a copy of the latencies recorded so far, ordered by SQL.
+func (h *LatencyHistogram) Snapshot() []StatementLatency {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	snapshot := make([]StatementLatency, 0, len(h.statements))
+	for _, stmt := range h.statements {
+		copied := *stmt
+		copied.Counts = append([]uint64(nil), stmt.Counts...)
+		snapshot = append(snapshot, copied)
+	}
+
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].SQL < snapshot[j].SQL })
+	return snapshot
+}
+
+// Collect exports the latencies as the contacts_db_statement_duration_seconds histogram, and the failures as the
+// contacts_db_statement_errors_total counter, labelled by statement. Statements are written with placeholders for
+// their arguments, so there are only as many series as the service has queries.
+func (h *LatencyHistogram) Collect() []metrics.Family {
+	duration := metrics.Family{
+		Name: "contacts_db_statement_duration_seconds",
+		Help: "Time taken by SQL statements run in Database transactions, by statement.",
+		Type: metrics.TypeHistogram,
+	}
+	failures := metrics.Family{
+		Name: "contacts_db_statement_errors_total",
+		Help: "Number of SQL statements run in Database transactions that failed, by statement.",
+		Type: metrics.TypeCounter,
+	}
+
+	for _, stmt := range h.Snapshot() {
+		statement := metrics.Label{Name: "statement", Value: strings.Join(strings.Fields(stmt.SQL), " ")}
+		bucket := func(le string, count uint64) metrics.Sample {
+			return metrics.Sample{
+				Suffix: "_bucket",
+				Labels: []metrics.Label{statement, {Name: "le", Value: le}},
+				Value:  float64(count),
+			}
+		}
+
+		for i, upperBound := range stmt.Buckets {
+			duration.Samples = append(duration.Samples, bucket(strconv.FormatFloat(upperBound.Seconds(), 'g', -1, 64), stmt.Counts[i]))
+		}
+		duration.Samples = append(duration.Samples,
+			bucket("+Inf", stmt.Count),
+			metrics.Sample{Suffix: "_sum", Labels: []metrics.Label{statement}, Value: stmt.Sum.Seconds()},
+			metrics.Sample{Suffix: "_count", Labels: []metrics.Label{statement}, Value: float64(stmt.Count)},
+		)
+		failures.Samples = append(failures.Samples, metrics.Sample{Labels: []metrics.Label{statement}, Value: float64(stmt.Errors)})
+	}
+
+	return []metrics.Family{duration, failures}
+}
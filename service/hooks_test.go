@@ -0,0 +1,94 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/circleci/cci-demo-docker/metrics"
+	"github.com/circleci/cci-demo-docker/service"
+	"github.com/circleci/cci-demo-docker/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingHook keeps every event it is notified of.
+type recordingHook struct {
+	mu      sync.Mutex
+	queries []service.QueryEvent
+	txs     []service.TxEvent
+}
+
+func (h *recordingHook) AfterQuery(event service.QueryEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.queries = append(h.queries, event)
+}
+
+func (h *recordingHook) AfterTx(event service.TxEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.txs = append(h.txs, event)
+}
+
+func Test_DatabaseHooks(t *testing.T) {
+	env := test.SetupEnv(t)
+	defer env.Close()
+
+	// SETUP:
+	hook := &recordingHook{}
+	env.DB.Hooks = append(env.DB.Hooks, hook)
+
+	// TEST: Adding a contact directly through the Database.
+	ctx := service.WithAccount(context.Background(), service.DefaultAccountID)
+	_, err := env.DB.AddContact(ctx, service.Contact{Email: "alice@example.xyz", Name: "Alice Zulu"})
+	require.NoError(t, err)
+
+	// VERIFY: The INSERT was reported with its string arguments redacted.
+	require.Len(t, hook.queries, 1)
+	assert.Contains(t, hook.queries[0].SQL, "INSERT INTO contacts")
+	assert.Equal(t, []interface{}{service.DefaultAccountID, "[REDACTED]", "[REDACTED]"}, hook.queries[0].Args)
+	assert.NoError(t, hook.queries[0].Err)
+
+	// VERIFY: The transaction was begun and committed.
+	require.Len(t, hook.txs, 2)
+	assert.Equal(t, service.TxBegin, hook.txs[0].Op)
+	assert.Equal(t, service.TxCommit, hook.txs[1].Op)
+}
+
+func Test_RedactArgs(t *testing.T) {
+	now := time.Now()
+	redacted := service.RedactArgs([]interface{}{42, "alice@example.xyz", true, nil, now, []byte("secret")})
+
+	assert.Equal(t, []interface{}{42, "[REDACTED]", true, nil, now, "[REDACTED]"}, redacted)
+}
+
+func Test_LatencyHistogram(t *testing.T) {
+	histogram := service.NewLatencyHistogram(10*time.Millisecond, 100*time.Millisecond)
+
+	histogram.AfterQuery(service.QueryEvent{SQL: "SELECT 1", Duration: 5 * time.Millisecond})
+	histogram.AfterQuery(service.QueryEvent{SQL: "SELECT 1", Duration: 50 * time.Millisecond})
+	histogram.AfterQuery(service.QueryEvent{SQL: "SELECT 1", Duration: time.Second})
+
+	histogram.AfterQuery(service.QueryEvent{SQL: "SELECT 2\n\tFROM contacts", Duration: time.Millisecond, Err: errors.New("Failed")})
+
+	snapshot := histogram.Snapshot()
+	require.Len(t, snapshot, 2)
+	assert.Equal(t, uint64(3), snapshot[0].Count)
+	assert.Equal(t, []uint64{1, 2}, snapshot[0].Counts)
+	assert.Equal(t, 1055*time.Millisecond, snapshot[0].Sum)
+
+	// The latencies are exported as metrics, with the statements on a single line.
+	registry := metrics.NewRegistry()
+	registry.MustRegister(histogram)
+	w := httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, w.Body.String(), `contacts_db_statement_duration_seconds_bucket{statement="SELECT 1",le="0.01"} 1`)
+	assert.Contains(t, w.Body.String(), `contacts_db_statement_duration_seconds_bucket{statement="SELECT 1",le="+Inf"} 3`)
+	assert.Contains(t, w.Body.String(), `contacts_db_statement_duration_seconds_sum{statement="SELECT 1"} 1.055`)
+	assert.Contains(t, w.Body.String(), `contacts_db_statement_errors_total{statement="SELECT 2 FROM contacts"} 1`)
+}
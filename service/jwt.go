@@ -0,0 +1,510 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ===== JWKS ==========================================================================================================
+
+// DefaultJWKSRefreshInterval is how often keys are reloaded, to pick up keys that are rotated out.
+const DefaultJWKSRefreshInterval = time.Hour
+
+// MinJWKSRefreshInterval is the least time between attempts to reload the keys. It limits how often tokens signed by
+// an unknown key reload them, to pick up keys that are rotated in without letting clients make the service reload them
+// on every request, and how often reloading is retried while the issuer is unavailable.
+var MinJWKSRefreshInterval = time.Minute
+
+// ErrKeysUnavailable is returned when tokens can't be verified as no keys could be loaded yet, e.g. as the issuer is
+// down when the service starts.
+var ErrKeysUnavailable = errors.New("Signing keys unavailable")
+
+// JWKS is a cached JSON Web Key Set, the public keys that tokens are signed with. Keys are reloaded periodically, and
+// when a token names a key that isn't known yet, so that the issuer can rotate its keys.
+type JWKS struct {
+	source          string
+	load            func(ctx context.Context) ([]byte, error)
+	refreshInterval time.Duration
+	now             func() time.Time
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	loadedAt    time.Time
+	lastAttempt time.Time
+	// err is why the last attempt to load the keys failed.
+	err error
+	// loading is closed when the reload in progress finishes, or nil if there is none.
+	loading chan struct{}
+}
+
+// NewJWKSFile creates a JWKS read from a file, e.g. one that is mounted from a secret.
+func NewJWKSFile(path string, refreshInterval time.Duration) *JWKS {
+	return newJWKS(path, refreshInterval, func(ctx context.Context) ([]byte, error) {
+		return ioutil.ReadFile(path)
+	})
+}
+
+// NewJWKSURL creates a JWKS fetched from a URL, like the jwks_uri of an OIDC provider.
+func NewJWKSURL(url string, refreshInterval time.Duration) *JWKS {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return newJWKS(url, refreshInterval, func(ctx context.Context) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+
+		response, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("JWKS endpoint responded %v", response.Status)
+		}
+
+		return ioutil.ReadAll(io.LimitReader(response.Body, 1<<20))
+	})
+}
+
+func newJWKS(source string, refreshInterval time.Duration, load func(ctx context.Context) ([]byte, error)) *JWKS {
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultJWKSRefreshInterval
+	}
+
+	return &JWKS{source: source, load: load, refreshInterval: refreshInterval, now: time.Now}
+}
+
+// Key returns the key with the given ID. A token without a key ID can only be verified if the set has a single key.
+// Keys are reloaded without holding up requests, which are verified with the keys loaded before meanwhile, unless
+// there are none yet or the token names an unknown key.
+func (k *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.keys == nil || k.now().Sub(k.loadedAt) >= k.refreshInterval {
+		loading := k.reload()
+		if k.keys == nil {
+			if err := k.wait(ctx, loading); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	key := k.find(kid)
+	if key == nil {
+		if loading := k.reload(); loading != nil {
+			if err := k.wait(ctx, loading); err != nil {
+				return nil, err
+			}
+			key = k.find(kid)
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("%w: unknown key ID %q", ErrInvalidToken, kid)
+	}
+
+	return key, nil
+}
+
+func (k *JWKS) find(kid string) crypto.PublicKey {
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key
+		}
+	}
+
+	return k.keys[kid]
+}
+
+// reload starts reloading the keys in the background, unless that was last attempted less than MinJWKSRefreshInterval
+// ago. It returns a channel that is closed when the reload in progress finishes, or nil if there is none. k.mu must be
+// held.
+func (k *JWKS) reload() <-chan struct{} {
+	if k.loading == nil && k.now().Sub(k.lastAttempt) >= MinJWKSRefreshInterval {
+		k.lastAttempt = k.now()
+		k.loading = make(chan struct{})
+		go k.refresh(k.loading)
+	}
+
+	return k.loading
+}
+
+// wait releases k.mu until the reload is done or ctx is canceled. It returns why the keys couldn't be loaded, an
+// ErrKeysUnavailable, if there are still none.
+func (k *JWKS) wait(ctx context.Context, loading <-chan struct{}) error {
+	if loading != nil {
+		k.mu.Unlock()
+		select {
+		case <-loading:
+		case <-ctx.Done():
+		}
+		k.mu.Lock()
+	}
+
+	if k.keys != nil {
+		return nil
+	} else if k.err != nil {
+		return k.err
+	}
+	return ctx.Err()
+}
+
+// refresh reloads the keys, then closes done. The keys already loaded are kept if that fails, so that an issuer that is
+// briefly unavailable doesn't fail every request. It isn't bound to the context of the request that started it, as
+// other requests may be waiting for it too.
+func (k *JWKS) refresh(done chan struct{}) {
+	data, err := k.load(context.Background())
+	var keys map[string]crypto.PublicKey
+	if err == nil {
+		keys, err = ParseJWKS(data)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	defer close(done)
+	k.loading = nil
+
+	if err != nil {
+		k.err = fmt.Errorf("%w: unable to load JWKS from %v: %v", ErrKeysUnavailable, k.source, err)
+		if k.keys != nil {
+			slog.Warn("Using the previously loaded JWKS", "error", k.err.Error())
+		}
+		return
+	}
+
+	k.keys = keys
+	k.loadedAt = k.now()
+	k.err = nil
+}
+
+// jwk is a JSON Web Key, see RFC 7517. Only the members of RSA and EC public keys are included.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// ParseJWKS parses a JSON Web Key Set, keyed by key ID. Keys that aren't RSA or P-256 signing keys are skipped.
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("Invalid JWKS: %v", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for i, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+
+		var publicKey crypto.PublicKey
+		switch {
+		case key.Kty == "RSA":
+			n, err := decodeBigInt(key.N)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid JWKS: key %d: n: %v", i, err)
+			}
+			e, err := decodeBigInt(key.E)
+			if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+				return nil, fmt.Errorf("Invalid JWKS: key %d: invalid e", i)
+			}
+			publicKey = &rsa.PublicKey{N: n, E: int(e.Int64())}
+
+		case key.Kty == "EC" && key.Crv == "P-256":
+			x, err := decodeBigInt(key.X)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid JWKS: key %d: x: %v", i, err)
+			}
+			y, err := decodeBigInt(key.Y)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid JWKS: key %d: y: %v", i, err)
+			}
+			if !elliptic.P256().IsOnCurve(x, y) {
+				return nil, fmt.Errorf("Invalid JWKS: key %d: the point isn't on the P-256 curve", i)
+			}
+			publicKey = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+
+		default:
+			continue
+		}
+
+		keys[key.Kid] = publicKey
+	}
+
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("expected base64url")
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// ===== JWT AUTHENTICATOR =============================================================================================
+
+// JWTAuthenticator authenticates requests with JSON Web Tokens signed with RS256 or ES256, like the ID and access
+// tokens of an OIDC provider.
+type JWTAuthenticator struct {
+	// Issuer must match the iss claim.
+	Issuer string
+	// Audience must be one of the values of the aud claim.
+	Audience string
+	Keys     *JWKS
+	// ClockSkew is how far the clocks of the issuer and the service may disagree, when checking exp and nbf.
+	ClockSkew time.Duration
+	// AccountClaim names the claim holding the ID of the caller's account, as a number or a string. Tokens without it
+	// are rejected. When empty, every caller belongs to the default account.
+	AccountClaim string
+	// DB, when set, is where the account named by the AccountClaim must exist. Tokens naming another account are
+	// rejected, rather than failing the caller's requests.
+	DB *Database
+}
+
+// DefaultClockSkew is a typical ClockSkew.
+const DefaultClockSkew = time.Minute
+
+// Authenticate verifies the signature and claims of token. The principal's ID is made from the issuer and the sub
+// claim, its scopes from the space separated scope claim, or the scp list, and its account from the AccountClaim.
+func (a *JWTAuthenticator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenNotRecognized
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, ErrTokenNotRecognized
+	}
+
+	key, err := a.Keys.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], parts[2]); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	if err := a.checkClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+
+	accountID, err := a.account(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := claims["sub"].(string)
+	name := subject
+	for _, claim := range []string{"name", "email"} {
+		if value, ok := claims[claim].(string); ok && value != "" {
+			name = value
+			break
+		}
+	}
+
+	return &Principal{
+		ID:        "jwt:" + a.Issuer + "#" + subject,
+		Name:      name,
+		Scopes:    jwtScopes(claims),
+		AccountID: accountID,
+		Claims:    claims,
+	}, nil
+}
+
+// account returns the ID of the caller's account from the AccountClaim.
+func (a *JWTAuthenticator) account(ctx context.Context, claims map[string]interface{}) (int, error) {
+	if a.AccountClaim == "" {
+		return DefaultAccountID, nil
+	}
+
+	var value string
+	switch claim := claims[a.AccountClaim].(type) {
+	case json.Number:
+		value = claim.String()
+	case string:
+		value = claim
+	}
+
+	accountID, err := strconv.Atoi(value)
+	if err != nil || accountID <= 0 {
+		return 0, fmt.Errorf("%w: missing or invalid %v claim", ErrInvalidToken, a.AccountClaim)
+	}
+
+	if a.DB != nil {
+		exists, err := a.DB.AccountExists(ctx, accountID)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, fmt.Errorf("%w: unknown account %d", ErrInvalidToken, accountID)
+		}
+	}
+
+	return accountID, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// verifyJWTSignature checks the signature of a token with the key, which must suit the algorithm. Other algorithms,
+// in particular none and the HMAC ones, are rejected.
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, encodedSignature string) error {
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	digest := sha256.Sum256([]byte(signed))
+
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: RS256 token signed with a key that isn't RSA", ErrInvalidToken)
+		}
+		if rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature) != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: ES256 token signed with a key that isn't EC", ErrInvalidToken)
+		}
+		if len(signature) != 64 {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, alg)
+	}
+
+	return nil
+}
+
+func (a *JWTAuthenticator) checkClaims(claims map[string]interface{}, now time.Time) error {
+	if iss, _ := claims["iss"].(string); iss != a.Issuer {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, iss)
+	}
+
+	audienceOK := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audienceOK = aud == a.Audience
+	case []interface{}:
+		for _, value := range aud {
+			if value == a.Audience {
+				audienceOK = true
+			}
+		}
+	}
+	if !audienceOK {
+		return fmt.Errorf("%w: the audience doesn't include %q", ErrInvalidToken, a.Audience)
+	}
+
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return fmt.Errorf("%w: sub is required", ErrInvalidToken)
+	}
+
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return fmt.Errorf("%w: exp is required", ErrInvalidToken)
+	}
+	if !now.Before(exp.Add(a.ClockSkew)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+
+	if _, present := claims["nbf"]; present {
+		nbf, ok := numericDate(claims["nbf"])
+		if !ok {
+			return fmt.Errorf("%w: invalid nbf", ErrInvalidToken)
+		}
+		if now.Add(a.ClockSkew).Before(nbf) {
+			return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+		}
+	}
+
+	return nil
+}
+
+// numericDate parses a NumericDate claim, a number of seconds since the epoch.
+func numericDate(value interface{}) (time.Time, bool) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, int64(seconds*float64(time.Second))), true
+}
+
+// jwtScopes reads the scope claim of RFC 8693, a space separated string, or the scp list used by some providers.
+func jwtScopes(claims map[string]interface{}) []string {
+	if scope, ok := claims["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+
+	scopes := []string{}
+	if scp, ok := claims["scp"].([]interface{}); ok {
+		for _, value := range scp {
+			if scope, ok := value.(string); ok {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	return scopes
+}
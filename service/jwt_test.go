@@ -0,0 +1,350 @@
+package service_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/circleci/cci-demo-docker/service"
+	"github.com/circleci/cci-demo-docker/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupIssuer(t *testing.T) (*test.Issuer, *service.JWTAuthenticator, func()) {
+	dir, err := ioutil.TempDir("", "contacts-jwt")
+	require.NoError(t, err)
+
+	issuer := test.NewIssuer(t, dir)
+	authenticator := &service.JWTAuthenticator{
+		Issuer:    issuer.URL,
+		Audience:  issuer.Audience,
+		Keys:      service.NewJWKSFile(issuer.JWKSFile, time.Hour),
+		ClockSkew: service.DefaultClockSkew,
+	}
+
+	return issuer, authenticator, func() { os.RemoveAll(dir) }
+}
+
+func Test_JWTAuthentication(t *testing.T) {
+	// SETUP:
+	issuer, authenticator, cleanup := setupIssuer(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: valid tokens signed with each algorithm
+	for _, kid := range []string{"rs256-1", "es256-2"} {
+		claims := issuer.Claims("alice", "contacts:read", "contacts:write")
+		claims["email"] = "alice@example.xyz"
+
+		principal, err := authenticator.Authenticate(ctx, issuer.Sign(kid, claims))
+
+		// VERIFY: The claims are mapped to the principal.
+		require.NoError(t, err, kid)
+		assert.Equal(t, "jwt:https://issuer.example.xyz#alice", principal.ID)
+		assert.Equal(t, "alice@example.xyz", principal.Name)
+		assert.Equal(t, []string{"contacts:read", "contacts:write"}, principal.Scopes)
+		assert.Equal(t, "alice", principal.Claims["sub"])
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: scopes in a list, and an audience list
+	{
+		claims := issuer.Claims("alice")
+		delete(claims, "scope")
+		claims["scp"] = []string{"contacts:read"}
+		claims["aud"] = []string{"billing", issuer.Audience}
+
+		principal, err := authenticator.Authenticate(ctx, issuer.Sign("es256-2", claims))
+
+		// VERIFY:
+		require.NoError(t, err)
+		assert.Equal(t, []string{"contacts:read"}, principal.Scopes)
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: a token that expired within the allowed clock skew
+	{
+		claims := issuer.Claims("alice")
+		claims["exp"] = time.Now().Add(-service.DefaultClockSkew / 2).Unix()
+
+		_, err := authenticator.Authenticate(ctx, issuer.Sign("rs256-1", claims))
+
+		// VERIFY:
+		assert.NoError(t, err)
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: invalid tokens
+	invalid := map[string]func(claims map[string]interface{}){
+		"issuer":    func(claims map[string]interface{}) { claims["iss"] = "https://evil.example.xyz" },
+		"audience":  func(claims map[string]interface{}) { claims["aud"] = "billing" },
+		"expired":   func(claims map[string]interface{}) { claims["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"no expiry": func(claims map[string]interface{}) { delete(claims, "exp") },
+		"not yet":   func(claims map[string]interface{}) { claims["nbf"] = time.Now().Add(time.Hour).Unix() },
+		"subject":   func(claims map[string]interface{}) { delete(claims, "sub") },
+	}
+	for name, change := range invalid {
+		claims := issuer.Claims("alice")
+		change(claims)
+
+		_, err := authenticator.Authenticate(ctx, issuer.Sign("rs256-1", claims))
+
+		// VERIFY:
+		assert.True(t, errors.Is(err, service.ErrInvalidToken), "%v: %v", name, err)
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: tokens with a tampered payload, or an algorithm that isn't allowed
+	{
+		token := issuer.Token("alice", "contacts:read")
+		parts := strings.Split(token, ".")
+		forged := issuer.Claims("mallory", "contacts:admin")
+		forgedToken := issuer.Sign("rs256-1", forged)
+		tampered := parts[0] + "." + strings.Split(forgedToken, ".")[1] + "." + parts[2]
+
+		none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"rs256-1"}`))
+		unsigned := none + "." + parts[1] + "."
+
+		hmac := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","kid":"rs256-1"}`))
+		symmetric := hmac + "." + parts[1] + "." + parts[2]
+
+		for name, token := range map[string]string{"tampered": tampered, "none": unsigned, "HS256": symmetric} {
+			_, err := authenticator.Authenticate(ctx, token)
+
+			// VERIFY:
+			assert.True(t, errors.Is(err, service.ErrInvalidToken), "%v: %v", name, err)
+		}
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: the account of the caller, from a claim
+	{
+		accounts := *authenticator
+		accounts.AccountClaim = "account_id"
+		withID := func(id interface{}) string {
+			claims := issuer.Claims("alice")
+			claims["account_id"] = id
+			return issuer.Sign("rs256-1", claims)
+		}
+
+		defaultPrincipal, defaultErr := authenticator.Authenticate(ctx, withID(7))
+		numberPrincipal, numberErr := accounts.Authenticate(ctx, withID(7))
+		stringPrincipal, stringErr := accounts.Authenticate(ctx, withID("7"))
+		_, missingErr := accounts.Authenticate(ctx, issuer.Token("alice"))
+		_, invalidErr := accounts.Authenticate(ctx, withID("acme"))
+
+		// VERIFY: Without an AccountClaim the caller belongs to the default account. With one, it is required.
+		require.NoError(t, defaultErr)
+		assert.Equal(t, service.DefaultAccountID, defaultPrincipal.AccountID)
+		require.NoError(t, numberErr)
+		assert.Equal(t, 7, numberPrincipal.AccountID)
+		require.NoError(t, stringErr)
+		assert.Equal(t, 7, stringPrincipal.AccountID)
+		assert.True(t, errors.Is(missingErr, service.ErrInvalidToken), "%v", missingErr)
+		assert.True(t, errors.Is(invalidErr, service.ErrInvalidToken), "%v", invalidErr)
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: a token that isn't a JWT, like an API key
+	{
+		_, err := authenticator.Authenticate(ctx, "ck_3f9a2b7c1d0e_secret")
+
+		// VERIFY: Another authenticator can try it.
+		assert.Equal(t, service.ErrTokenNotRecognized, err)
+	}
+}
+
+func Test_JWKSRotation(t *testing.T) {
+	// SETUP: A JWKS served over HTTP, refreshed as soon as a token names an unknown key.
+	issuer, _, cleanup := setupIssuer(t)
+	defer cleanup()
+
+	minRefreshInterval := service.MinJWKSRefreshInterval
+	service.MinJWKSRefreshInterval = 0
+	defer func() { service.MinJWKSRefreshInterval = minRefreshInterval }()
+
+	fetches := 0
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(issuer.JWKS())
+	}))
+	defer jwksServer.Close()
+
+	authenticator := &service.JWTAuthenticator{
+		Issuer:   issuer.URL,
+		Audience: issuer.Audience,
+		Keys:     service.NewJWKSURL(jwksServer.URL, time.Hour),
+	}
+	ctx := context.Background()
+
+	_, err := authenticator.Authenticate(ctx, issuer.Token("alice"))
+	require.NoError(t, err)
+	_, err = authenticator.Authenticate(ctx, issuer.Token("alice"))
+	require.NoError(t, err)
+	require.Equal(t, 1, fetches, "Keys should be cached")
+
+	// TEST: The issuer rotates in a new key, and rotates out the old one.
+	kid := issuer.AddKey("ES256")
+	issuer.RemoveKey("rs256-1")
+	_, newKeyErr := authenticator.Authenticate(ctx, issuer.Sign(kid, issuer.Claims("alice")))
+
+	// VERIFY: The new key is picked up, and the old one is dropped.
+	assert.NoError(t, newKeyErr)
+	assert.Equal(t, 2, fetches)
+
+	_, err = authenticator.Authenticate(ctx, issuer.Sign("es256-2", issuer.Claims("alice")))
+	assert.NoError(t, err)
+}
+
+// fakeClock is a clock that only moves when it is told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func Test_JWKSRefreshFailure(t *testing.T) {
+	// SETUP: A JWKS on a fake clock, served by an issuer that hangs until it is released and then fails after the first
+	// load.
+	issuer, _, cleanup := setupIssuer(t)
+	defer cleanup()
+
+	var fetches int32
+	release := make(chan struct{})
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-release
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(issuer.JWKS())
+	}))
+	defer jwksServer.Close()
+
+	clock := &fakeClock{now: time.Now()}
+	keys := service.NewJWKSURL(jwksServer.URL, time.Hour)
+	service.SetJWKSClock(keys, clock.Now)
+	ctx := context.Background()
+	_, err := keys.Key(ctx, "rs256-1")
+	require.NoError(t, err)
+
+	// -----------------------------------------------------------------------------------------------------------------
+	// TEST: Requests once the keys are stale, while the reload hangs.
+	clock.Advance(time.Hour)
+	for i := 0; i < 10; i++ {
+		key, err := keys.Key(ctx, "rs256-1")
+
+		// VERIFY: The keys loaded before are used without waiting.
+		require.NoError(t, err)
+		assert.NotNil(t, key)
+	}
+	release <- struct{}{}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches), "Keys should be reloaded once at a time")
+
+	// -----------------------------------------------------------------------------------------------------------------
+	// TEST: Requests after the reload failed. A token naming an unknown key waits for the reload in progress.
+	_, unknownErr := keys.Key(ctx, "es256-9")
+	for i := 0; i < 10; i++ {
+		_, err := keys.Key(ctx, "rs256-1")
+		require.NoError(t, err)
+	}
+	_, err = keys.Key(ctx, "es256-9")
+
+	// VERIFY: Reloading isn't retried until MinJWKSRefreshInterval has passed.
+	assert.True(t, errors.Is(unknownErr, service.ErrInvalidToken), "%v", unknownErr)
+	assert.True(t, errors.Is(err, service.ErrInvalidToken), "%v", err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+
+	// -----------------------------------------------------------------------------------------------------------------
+	// TEST: A token naming an unknown key once MinJWKSRefreshInterval has passed.
+	clock.Advance(service.MinJWKSRefreshInterval)
+	go func() { release <- struct{}{} }()
+	_, err = keys.Key(ctx, "es256-9")
+
+	// VERIFY: Reloading is retried.
+	assert.True(t, errors.Is(err, service.ErrInvalidToken), "%v", err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&fetches))
+}
+
+func Test_JWKSUnavailable(t *testing.T) {
+	// SETUP: A server accepting JWTs signed with keys that can't be loaded.
+	issuer, _, cleanup := setupIssuer(t)
+	defer cleanup()
+
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer jwksServer.Close()
+
+	authenticator := &service.JWTAuthenticator{
+		Issuer:   issuer.URL,
+		Audience: issuer.Audience,
+		Keys:     service.NewJWKSURL(jwksServer.URL, time.Hour),
+	}
+	server := service.NewServer(nil, service.WithAuthentication(authenticator))
+
+	// TEST:
+	_, err := authenticator.Keys.Key(context.Background(), "rs256-1")
+	r := httptest.NewRequest(http.MethodGet, "/v1/contacts/%20", nil)
+	r.Header.Set("Authorization", "Bearer "+issuer.Token("alice", "contacts:read"))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+
+	// VERIFY: The token isn't rejected, the service is unavailable until the keys are loaded.
+	assert.True(t, errors.Is(err, service.ErrKeysUnavailable), "%v", err)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"unavailable"`)
+	assert.NotContains(t, w.Body.String(), jwksServer.URL)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+}
+
+func Test_JWTServer(t *testing.T) {
+	// SETUP: A server accepting JWTs. The database is never reached.
+	issuer, authenticator, cleanup := setupIssuer(t)
+	defer cleanup()
+	server := service.NewServer(nil, service.WithAuthentication(authenticator))
+	get := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/v1/contacts/%20", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	expired := issuer.Claims("alice")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+
+	// TEST:
+	valid := get(issuer.Token("alice", "contacts:read"))
+	rejected := get(issuer.Sign("rs256-1", expired))
+
+	// VERIFY: The valid token reaches the handler, which rejects the blank email.
+	assert.Equal(t, http.StatusBadRequest, valid.Code)
+	assert.Equal(t, http.StatusUnauthorized, rejected.Code)
+	assert.Contains(t, rejected.Body.String(), "expired")
+	assert.Contains(t, rejected.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+}
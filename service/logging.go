@@ -0,0 +1,220 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/circleci/cci-demo-docker/tracing"
+	"github.com/julienschmidt/httprouter"
+)
+
+// RequestIDHeader carries the ID of a request, so that its log lines can be found. A valid ID sent by the client, or
+// a proxy in front of the service, is used as is; otherwise one is generated. The ID is always sent in the response.
+const RequestIDHeader = "X-Request-ID"
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	requestInfoKey
+	principalKey
+	accountKey
+)
+
+// WithRequestID returns a copy of ctx carrying the given request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the ID of the request that ctx belongs to, or "" if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// NewRequestID generates a random request ID.
+func NewRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// validRequestID limits the IDs accepted from clients to something that is safe to log and echo back.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ===== LOG HANDLER ===================================================================================================
+
+// NewLogHandler wraps h to add the ID of the current request, and of the current trace, to every record logged with a
+// context, e.g. with slog.InfoContext. Wrapping a handler more than once has no further effect.
+func NewLogHandler(h slog.Handler) slog.Handler {
+	if _, ok := h.(*contextHandler); ok {
+		return h
+	}
+
+	return &contextHandler{h}
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := tracing.SpanContextFromContext(ctx); sc.IsValid() {
+		record.AddAttrs(slog.String("trace_id", sc.TraceID.String()))
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
+}
+
+// newLogger returns logger, or the default logger when it is nil, adding request IDs to its records.
+func newLogger(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return slog.New(NewLogHandler(logger.Handler()))
+}
+
+// ===== ACCESS LOG ====================================================================================================
+
+// requestInfo is filled in while a request is handled, to be included in its access log line.
+type requestInfo struct {
+	route   string
+	traceID string
+}
+
+// withRoute wraps the handler of a route to record its pattern, e.g. /v1/contacts/:email, for the access log.
+func withRoute(path string, handler httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if info, ok := r.Context().Value(requestInfoKey).(*requestInfo); ok {
+			info.route = path
+		}
+		handler(w, r, ps)
+	}
+}
+
+// routePattern returns the pattern of the route that matched r, or "" if none did.
+func routePattern(r *http.Request) string {
+	if info, ok := r.Context().Value(requestInfoKey).(*requestInfo); ok {
+		return info.route
+	}
+
+	return ""
+}
+
+// accessLog assigns each request an ID, then logs one line describing it once it has been handled.
+func (s *Server) accessLog(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	start := time.Now()
+
+	id := r.Header.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		id = NewRequestID()
+	}
+	w.Header().Set(RequestIDHeader, id)
+
+	info := &requestInfo{}
+	ctx := context.WithValue(WithRequestID(r.Context(), id), requestInfoKey, info)
+	recorder := &statusRecorder{ResponseWriter: w}
+
+	defer func() {
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		duration := time.Since(start)
+		route := info.route
+		if route == "" {
+			route = unmatchedRoute
+		}
+		observeRequest(r.Method, route, recorder.status, duration)
+
+		level := slog.LevelInfo
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.Int("status", recorder.status),
+			slog.Int64("bytes", recorder.bytes),
+			slog.Duration("duration", duration),
+			slog.String("remote_addr", r.RemoteAddr),
+		}
+		if info.traceID != "" {
+			attrs = append(attrs, slog.String("trace_id", info.traceID))
+		}
+		s.logger.LogAttrs(ctx, level, "Request", attrs...)
+	}()
+
+	next(recorder, r.WithContext(ctx))
+}
+
+// statusRecorder records the status code and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusRecorder) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Hijacking is not supported")
+	}
+	return hijacker.Hijack()
+}
@@ -0,0 +1,103 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/circleci/cci-demo-docker/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// logLines decodes each JSON record written to buf.
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record), line)
+		lines = append(lines, record)
+	}
+
+	return lines
+}
+
+func Test_AccessLog(t *testing.T) {
+	// SETUP:
+	var buf bytes.Buffer
+	server := service.NewServer(nil, service.WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+
+	// TEST: A request with an ID, that fails.
+	r := httptest.NewRequest(http.MethodGet, "/v1/contacts/%20", nil)
+	r.Header.Set(service.RequestIDHeader, "req-1234")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+
+	// VERIFY: The ID is echoed in the header and the error.
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "req-1234", w.Header().Get(service.RequestIDHeader))
+
+	var response service.Problem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "req-1234", response.RequestID)
+
+	// VERIFY: A single access log line describes the request.
+	lines := logLines(t, &buf)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "Request", lines[0]["msg"])
+	assert.Equal(t, "req-1234", lines[0]["request_id"])
+	assert.Equal(t, "GET", lines[0]["method"])
+	assert.Equal(t, "/v1/contacts/:email", lines[0]["route"])
+	assert.Equal(t, float64(http.StatusBadRequest), lines[0]["status"])
+	assert.Equal(t, float64(w.Body.Len()), lines[0]["bytes"])
+	assert.Equal(t, r.RemoteAddr, lines[0]["remote_addr"])
+	assert.Contains(t, lines[0], "duration")
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: a request that doesn't match a route
+	buf.Reset()
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/nothing", nil))
+
+	// VERIFY: It is logged under the same route as in the metrics.
+	lines = logLines(t, &buf)
+	require.Len(t, lines, 1)
+	assert.Equal(t, float64(http.StatusNotFound), lines[0]["status"])
+	assert.Equal(t, "unmatched", lines[0]["route"])
+}
+
+func Test_RequestIDGenerated(t *testing.T) {
+	server := service.NewServer(nil, service.WithLogger(slog.New(slog.NewJSONHandler(&bytes.Buffer{}, nil))))
+
+	for _, sent := range []string{"", "has spaces", strings.Repeat("x", 129)} {
+		r := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		r.Header.Set(service.RequestIDHeader, sent)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+
+		id := w.Header().Get(service.RequestIDHeader)
+		assert.Len(t, id, 32, "Invalid IDs are replaced: %q", sent)
+	}
+}
+
+func Test_SlowQueryLoggerIncludesRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	hook := &service.SlowQueryLogger{Logger: slog.New(slog.NewJSONHandler(&buf, nil))}
+
+	hook.AfterQuery(service.QueryEvent{
+		Context:  service.WithRequestID(context.Background(), "req-5678"),
+		SQL:      "SELECT 1",
+		Duration: time.Second,
+	})
+
+	lines := logLines(t, &buf)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "Slow query", lines[0]["msg"])
+	assert.Equal(t, "req-5678", lines[0]["request_id"])
+	assert.Equal(t, "SELECT 1", lines[0]["sql"])
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/circleci/cci-demo-docker/metrics"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Transaction outcomes, as reported by the contacts_db_transaction_duration_seconds metric.
+const (
+	outcomeCommit   = "commit"
+	outcomeRollback = "rollback"
+	outcomePanic    = "panic"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec(
+		"contacts_http_requests_total",
+		"Number of HTTP requests handled, by method, route pattern and status code.",
+		"method", "route", "status",
+	)
+	httpRequestDuration = metrics.NewHistogramVec(
+		"contacts_http_request_duration_seconds",
+		"Time taken to handle HTTP requests, by method, route pattern and status code.",
+		nil,
+		"method", "route", "status",
+	)
+	httpPanicsRecovered = metrics.NewCounterVec(
+		"contacts_http_panics_recovered_total",
+		"Number of panics in handlers that were recovered and answered with an error, by route pattern.",
+		"route",
+	)
+	dbTransactionDuration = metrics.NewHistogramVec(
+		"contacts_db_transaction_duration_seconds",
+		"Time taken by Database.Read and Database.Write transactions, by mode (read or write) and outcome (commit, rollback or panic).",
+		nil,
+		"mode", "outcome",
+	)
+)
+
+func init() {
+	metrics.MustRegister(httpRequests, httpRequestDuration, httpPanicsRecovered, dbTransactionDuration)
+}
+
+// Metrics handles HTTP requests for the metrics of every subsystem, in the Prometheus text format.
+func (s *Server) Metrics(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	metrics.Default.ServeHTTP(w, r)
+}
+
+// unmatchedRoute is the route that requests which didn't match one are recorded under, in metrics and the access
+// log. Grouping them means unknown paths can't create an unbounded number of series.
+const unmatchedRoute = "unmatched"
+
+// observeRequest records a handled request.
+func observeRequest(method string, route string, status int, duration time.Duration) {
+	labels := []string{method, route, strconv.Itoa(status)}
+	httpRequests.With(labels...).Inc()
+	httpRequestDuration.With(labels...).ObserveDuration(duration)
+}
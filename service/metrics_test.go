@@ -0,0 +1,35 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/circleci/cci-demo-docker/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Metrics(t *testing.T) {
+	// SETUP:
+	server := service.NewServer(nil)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	// TEST: Requests to a route, and to an unknown path.
+	get("/healthz")
+	get("/no/such/path")
+	w := get("/metrics")
+
+	// VERIFY: Requests are counted by route pattern, rather than path.
+	require.Equal(t, http.StatusOK, w.Code)
+	out := w.Body.String()
+	assert.Contains(t, out, `contacts_http_requests_total{method="GET",route="/healthz",status="200"}`)
+	assert.Contains(t, out, `contacts_http_requests_total{method="GET",route="unmatched",status="404"}`)
+	assert.Contains(t, out, `contacts_http_request_duration_seconds_bucket{method="GET",route="/healthz",status="200",le="+Inf"}`)
+	assert.Contains(t, out, "# TYPE contacts_db_transaction_duration_seconds histogram")
+	assert.Contains(t, out, "# TYPE contacts_http_panics_recovered_total counter")
+}
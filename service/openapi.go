@@ -0,0 +1,415 @@
+package service
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// openAPIDocument describes every route served by the Server. Keep it up to date when adding or changing routes, the
+// tests fail if a route is missing from it.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// OpenAPI handles HTTP requests for the OpenAPI 3 document describing the service.
+func (s *Server) OpenAPI(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPIDocument)
+}
+
+// OpenAPIPath converts a path in the router's syntax, like /contacts/:email, to a path template of the OpenAPI spec,
+// like /contacts/{email}.
+func OpenAPIPath(routerPath string) string {
+	segments := strings.Split(routerPath, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// ===== SPEC ==========================================================================================================
+
+// Spec is a parsed OpenAPI document, which requests and responses can be validated against. Only the parts of JSON
+// Schema used by this service are supported: $ref, type, properties, required, additionalProperties, items, enum,
+// minLength and maxLength.
+type Spec struct {
+	document   map[string]interface{}
+	operations []*operation
+}
+
+// operation is a method and path template of the spec, with its definition.
+type operation struct {
+	method     string
+	path       string
+	segments   []string
+	definition map[string]interface{}
+}
+
+var (
+	defaultSpec     *Spec
+	defaultSpecOnce sync.Once
+)
+
+// DefaultSpec returns the spec served at /openapi.json.
+func DefaultSpec() *Spec {
+	defaultSpecOnce.Do(func() {
+		spec, err := LoadSpec(openAPIDocument)
+		if err != nil {
+			panic(fmt.Sprintf("Invalid embedded OpenAPI document: %v", err))
+		}
+		defaultSpec = spec
+	})
+
+	return defaultSpec
+}
+
+// LoadSpec parses an OpenAPI 3 document in JSON.
+func LoadSpec(data []byte) (*Spec, error) {
+	var document map[string]interface{}
+	if err := decodeJSONValue(data, &document); err != nil {
+		return nil, fmt.Errorf("Unable to parse OpenAPI document: %v", err)
+	}
+
+	paths, ok := document["paths"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("OpenAPI document has no paths")
+	}
+
+	spec := &Spec{document: document}
+	for path, item := range paths {
+		methods, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Invalid OpenAPI path %v", path)
+		}
+
+		for method, definition := range methods {
+			definition, ok := definition.(map[string]interface{})
+			if !ok {
+				continue // e.g. a list of parameters shared by every method
+			}
+
+			spec.operations = append(spec.operations, &operation{
+				method:     strings.ToUpper(method),
+				path:       path,
+				segments:   strings.Split(path, "/"),
+				definition: definition,
+			})
+		}
+	}
+
+	// Literal segments win over templated ones, like a router would, by matching more specific paths first.
+	sort.Slice(spec.operations, func(i, j int) bool {
+		return strings.Count(spec.operations[i].path, "{") < strings.Count(spec.operations[j].path, "{")
+	})
+
+	return spec, nil
+}
+
+// HasOperation reports whether the spec describes the given method and path template, e.g. GET /contacts/{email}.
+func (s *Spec) HasOperation(method string, path string) bool {
+	for _, op := range s.operations {
+		if op.method == method && op.path == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+// find returns the operation matching the method and path of a request, or nil if there is none.
+func (s *Spec) find(method string, path string) *operation {
+	segments := strings.Split(path, "/")
+	for _, op := range s.operations {
+		if op.method == method && op.matches(segments) {
+			return op
+		}
+	}
+
+	return nil
+}
+
+func (op *operation) matches(segments []string) bool {
+	if len(segments) != len(op.segments) {
+		return false
+	}
+
+	for i, segment := range op.segments {
+		if strings.HasPrefix(segment, "{") {
+			if segments[i] == "" {
+				return false
+			}
+		} else if segment != segments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ValidateRequest checks the body of r against the spec. Requests that don't match any operation are not validated,
+// as the router responds to them with a 404 or 405. The body of r can still be read afterwards.
+func (s *Spec) ValidateRequest(r *http.Request) error {
+	op := s.find(r.Method, r.URL.EscapedPath())
+	if op == nil {
+		return nil
+	}
+
+	requestBody, ok := s.resolve(op.definition["requestBody"]).(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return fmt.Errorf("Unable to read request body: %w", err)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if len(body) == 0 {
+		if required, _ := requestBody["required"].(bool); required {
+			return fmt.Errorf("A request body is required")
+		}
+		return nil
+	}
+
+	schema, err := s.schemaFor(requestBody, r.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+
+	return s.validateBody(schema, body)
+}
+
+// ValidateResponse checks that the status code of a response to r is documented, and that its body matches the
+// documented schema. Responses to requests that don't match any operation are not validated.
+func (s *Spec) ValidateResponse(r *http.Request, statusCode int, header http.Header, body []byte) error {
+	op := s.find(r.Method, r.URL.EscapedPath())
+	if op == nil {
+		return nil
+	}
+
+	responses, _ := op.definition["responses"].(map[string]interface{})
+	response, ok := responses[strconv.Itoa(statusCode)]
+	if !ok {
+		response, ok = responses["default"]
+	}
+	if !ok {
+		return fmt.Errorf("%v %v: status %v is not documented", op.method, op.path, statusCode)
+	}
+
+	definition, _ := s.resolve(response).(map[string]interface{})
+	if _, ok := definition["content"]; !ok {
+		return nil
+	}
+
+	schema, err := s.schemaFor(definition, header.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("%v %v: %v response: %v", op.method, op.path, statusCode, err)
+	}
+
+	if err := s.validateBody(schema, body); err != nil {
+		return fmt.Errorf("%v %v: %v response: %v", op.method, op.path, statusCode, err)
+	}
+
+	return nil
+}
+
+// schemaFor returns the schema of a request body or response for the given Content-Type.
+func (s *Spec) schemaFor(definition map[string]interface{}, contentType string) (interface{}, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, &DecodeError{StatusCode: http.StatusUnsupportedMediaType, Code: CodeUnsupportedMediaType, Message: fmt.Sprintf("Invalid Content-Type %q", contentType)}
+	}
+
+	content, _ := definition["content"].(map[string]interface{})
+	media, ok := content[mediaType].(map[string]interface{})
+	if !ok {
+		return nil, &DecodeError{StatusCode: http.StatusUnsupportedMediaType, Code: CodeUnsupportedMediaType, Message: fmt.Sprintf("Unsupported Content-Type %q", mediaType)}
+	}
+
+	// Only JSON bodies are validated against their schema
+	if mediaType != "application/json" {
+		return nil, nil
+	}
+
+	return media["schema"], nil
+}
+
+func (s *Spec) validateBody(schema interface{}, body []byte) error {
+	if schema == nil {
+		return nil
+	}
+
+	var value interface{}
+	if err := decodeJSONValue(body, &value); err != nil {
+		return fmt.Errorf("Invalid JSON: %v", err)
+	}
+
+	return s.validate(schema, value, "")
+}
+
+// resolve follows a local $ref, like #/components/schemas/Contact.
+func (s *Spec) resolve(definition interface{}) interface{} {
+	for {
+		object, ok := definition.(map[string]interface{})
+		if !ok {
+			return definition
+		}
+		ref, ok := object["$ref"].(string)
+		if !ok {
+			return definition
+		}
+
+		var target interface{} = s.document
+		for _, key := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			parent, _ := target.(map[string]interface{})
+			target = parent[key]
+		}
+		if target == nil {
+			panic(fmt.Sprintf("Unresolvable OpenAPI reference %v", ref))
+		}
+		definition = target
+	}
+}
+
+// validate checks value against schema, naming the location of the first mismatch with a path like contact.email.
+func (s *Spec) validate(schema interface{}, value interface{}, path string) error {
+	definition, ok := s.resolve(schema).(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	fail := func(format string, args ...interface{}) error {
+		location := path
+		if location == "" {
+			location = "body"
+		}
+		return fmt.Errorf("%v: "+format, append([]interface{}{location}, args...)...)
+	}
+
+	if enum, ok := definition["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				found = true
+			}
+		}
+		if !found {
+			return fail("must be one of %v", enum)
+		}
+	}
+
+	switch definition["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fail("must be an object")
+		}
+
+		properties, _ := definition["properties"].(map[string]interface{})
+		required, _ := definition["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				return fail("%v is required", name)
+			}
+		}
+
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			property, ok := properties[name]
+			if !ok {
+				if additional, ok := definition["additionalProperties"].(bool); ok && !additional {
+					return fail("unknown field %v", name)
+				}
+				continue
+			}
+			if err := s.validate(property, object[name], joinPath(path, name)); err != nil {
+				return err
+			}
+		}
+
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fail("must be an array")
+		}
+		for i, item := range items {
+			if err := s.validate(definition["items"], item, fmt.Sprintf("%v[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fail("must be a string")
+		}
+		if min, ok := definition["minLength"].(json.Number); ok {
+			if n, _ := min.Int64(); int64(len([]rune(str))) < n {
+				return fail("must be at least %v characters", n)
+			}
+		}
+		if max, ok := definition["maxLength"].(json.Number); ok {
+			if n, _ := max.Int64(); int64(len([]rune(str))) > n {
+				return fail("must be at most %v characters", n)
+			}
+		}
+
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			return fail("must be an integer")
+		}
+		if _, err := number.Int64(); err != nil {
+			return fail("must be an integer")
+		}
+
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return fail("must be a number")
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fail("must be true or false")
+		}
+	}
+
+	return nil
+}
+
+func joinPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+
+	return path + "." + name
+}
+
+// decodeJSONValue decodes data keeping numbers as json.Number, so that integers can be told apart.
+func decodeJSONValue(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
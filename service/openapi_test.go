@@ -0,0 +1,91 @@
+package service_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/circleci/cci-demo-docker/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_OpenAPICoversEveryRoute(t *testing.T) {
+	server := service.NewServer(nil)
+	spec := service.DefaultSpec()
+
+	routes := server.Routes()
+	require.NotEmpty(t, routes)
+
+	for _, route := range routes {
+		path := service.OpenAPIPath(route.Path)
+		assert.True(t, spec.HasOperation(route.Method, path), "%v %v is missing from openapi.json", route.Method, path)
+	}
+}
+
+func Test_OpenAPIServed(t *testing.T) {
+	server := service.NewServer(nil)
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var document map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &document))
+	assert.Equal(t, "3.0.3", document["openapi"])
+}
+
+func Test_RequestValidation(t *testing.T) {
+	server := service.NewServer(nil, service.WithRequestValidation())
+
+	post := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/contacts", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	// VERIFY: Requests that don't match the spec are rejected before reaching the handler, which would use the nil
+	// database.
+	for body, message := range map[string]string{
+		`{"name": "Alice Zulu"}`:                              "body: email is required",
+		`{"email": 42, "name": "Alice Zulu"}`:                 "email: must be a string",
+		`{"email": "alice@example.xyz", "name": "A", "x": 1}`: "body: unknown field x",
+		`{"email": "alice@example.xyz"`:                       "Invalid JSON: unexpected EOF",
+	} {
+		w := post(body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+
+		var response service.Problem
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, message, response.Detail, body)
+	}
+
+	// VERIFY: Routes that aren't in the spec are left to the router.
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/nope", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func Test_ResponseValidation(t *testing.T) {
+	spec := service.DefaultSpec()
+	r := httptest.NewRequest(http.MethodGet, "/v1/contacts/alice@example.xyz", nil)
+	header := http.Header{"Content-Type": {"application/json"}}
+
+	assert.NoError(t, spec.ValidateResponse(r, http.StatusOK, header, []byte(`{"contact": {"id": 1, "email": "a", "name": "b"}}`)))
+	assert.EqualError(
+		t,
+		spec.ValidateResponse(r, http.StatusOK, header, []byte(`{"contact": {"id": "1", "email": "a", "name": "b"}}`)),
+		"GET /v1/contacts/{email}: 200 response: contact.id: must be an integer",
+	)
+	assert.EqualError(
+		t,
+		spec.ValidateResponse(r, http.StatusTeapot, header, nil),
+		"GET /v1/contacts/{email}: status 418 is not documented",
+	)
+}
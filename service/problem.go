@@ -0,0 +1,232 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// Problem is the RFC 7807 application/problem+json body of an error response. Unlike its Detail, its Code is stable, so
+// clients should tell errors apart by it.
+type Problem struct {
+	// Type is a URI identifying the kind of problem, one for each Code.
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Instance is the path of the request that had the problem.
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+	// Errors are the fields of the request body at fault, if the problem is with them.
+	Errors []FieldError `json:"errors,omitempty"`
+	// RequestID identifies the request in the service's logs.
+	RequestID string `json:"request_id,omitempty"`
+	// IncidentID identifies the unexpected error in the service's logs, which describe it in full.
+	IncidentID string `json:"incident_id,omitempty"`
+}
+
+// FieldError describes a problem with one field of a request body.
+type FieldError struct {
+	// Field is a JSONPath like $.email.
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+// ProblemContentType is the Content-Type of Problem responses.
+const ProblemContentType = "application/problem+json"
+
+// ProblemTypePrefix is prefixed to codes to make the Type of a Problem.
+const ProblemTypePrefix = "urn:contacts:problem:"
+
+// Codes of Problems. They won't change, though new ones may be added.
+const (
+	CodeInvalidRequest       = "invalid_request"
+	CodeInvalidJSON          = "invalid_json"
+	CodeInvalidField         = "invalid_field"
+	CodeInvalidEmail         = "invalid_email"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeBodyTooLarge         = "body_too_large"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeRateLimited          = "rate_limited"
+	CodeInternal             = "internal"
+	CodeUnavailable          = "unavailable"
+)
+
+// statusCodes are the codes of Problems that don't have a more specific one.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeInvalidRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusRequestEntityTooLarge: CodeBodyTooLarge,
+	http.StatusUnsupportedMediaType:  CodeUnsupportedMediaType,
+	http.StatusTooManyRequests:       CodeRateLimited,
+	http.StatusInternalServerError:   CodeInternal,
+	http.StatusServiceUnavailable:    CodeUnavailable,
+}
+
+// codeForStatus returns the code of a Problem with the given status code, when nothing more specific is known.
+func codeForStatus(statusCode int) string {
+	if code, ok := statusCodes[statusCode]; ok {
+		return code
+	}
+	if statusCode >= 500 {
+		return CodeInternal
+	}
+
+	return CodeInvalidRequest
+}
+
+// ProblemDetails is implemented by ServerErrors that have a more specific code than their status code, or are about
+// fields of the request body.
+type ProblemDetails interface {
+	ServerError
+	ProblemCode() string
+	ProblemErrors() []FieldError
+}
+
+// ===== WRITING PROBLEMS ==============================================================================================
+
+// writeServerError writes err as an error response, with its code and fields if it has ProblemDetails.
+func writeServerError(w http.ResponseWriter, r *http.Request, err ServerError) {
+	problem := &Problem{Status: err.HttpStatusCode(), Detail: err.HttpStatusMessage()}
+
+	if details, ok := err.(ProblemDetails); ok {
+		problem.Code = details.ProblemCode()
+		problem.Errors = details.ProblemErrors()
+	}
+
+	writeProblem(w, r, problem)
+}
+
+// writeProblem writes an error response, completing the problem's Type, Title, Code, Instance and RequestID if they're
+// empty. Clients that prefer application/json to application/problem+json get an ErrorResponse instead.
+func writeProblem(w http.ResponseWriter, r *http.Request, problem *Problem) {
+	if problem.Code == "" {
+		problem.Code = codeForStatus(problem.Status)
+	}
+	if problem.Type == "" {
+		problem.Type = ProblemTypePrefix + problem.Code
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(problem.Status)
+	}
+	if problem.Instance == "" {
+		problem.Instance = r.URL.Path
+	}
+	if problem.RequestID == "" {
+		problem.RequestID = RequestID(r.Context())
+	}
+
+	if !acceptsProblems(r) {
+		message := problem.Detail
+		if message == "" {
+			message = problem.Title
+		}
+
+		writeJSON(w, problem.Status, &ErrorResponse{
+			StatusCode: problem.Status,
+			Message:    message,
+			RequestID:  problem.RequestID,
+			IncidentID: problem.IncidentID,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(problem.Status)
+
+	encoder := json.NewEncoder(w)
+	encoder.Encode(problem)
+}
+
+// writeUnexpectedError writes an error response for an error the handler didn't expect. Its message may describe the
+// service's internals, e.g. SQL or database addresses, so it is only logged, along with the stack and request, under an
+// incident ID that the client is given instead. Errors that are ServerErrors are written as they describe themselves.
+func (s *Server) writeUnexpectedError(w http.ResponseWriter, r *http.Request, err error) {
+	var serverError ServerError
+	if errors.As(err, &serverError) {
+		writeServerError(w, r, serverError)
+		return
+	}
+
+	incidentID := NewIncidentID()
+	attrs := []slog.Attr{
+		slog.String("incident_id", incidentID),
+		slog.String("error", err.Error()),
+		slog.String("stack", string(debug.Stack())),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("route", routePattern(r)),
+		slog.String("remote_addr", r.RemoteAddr),
+	}
+	if principal := PrincipalFromContext(r.Context()); principal != nil {
+		attrs = append(attrs, slog.String("principal", principal.ID))
+	}
+	if accountID, ok := AccountFromContext(r.Context()); ok {
+		attrs = append(attrs, slog.Int("account_id", accountID))
+	}
+	s.logger.LogAttrs(r.Context(), slog.LevelError, "Unexpected error", attrs...)
+
+	writeProblem(w, r, &Problem{
+		Status:     http.StatusInternalServerError,
+		Detail:     fmt.Sprintf("An unexpected error occurred. Quote incident %v when reporting it.", incidentID),
+		IncidentID: incidentID,
+	})
+}
+
+// NewIncidentID generates a random incident ID.
+func NewIncidentID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// acceptsProblems reports whether the client accepts application/problem+json errors. It does unless its Accept
+// header prefers application/json, which gets the ErrorResponse errors were written as before Problems.
+func acceptsProblems(r *http.Request) bool {
+	problem, legacy, wildcard := -1.0, -1.0, -1.0
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(accepted)
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+
+		switch mediaType {
+		case ProblemContentType:
+			problem = math.Max(problem, q)
+		case "application/json":
+			legacy = math.Max(legacy, q)
+		case "*/*", "application/*":
+			wildcard = math.Max(wildcard, q)
+		}
+	}
+
+	if problem < 0 {
+		problem = wildcard
+	}
+	return legacy <= problem
+}
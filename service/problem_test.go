@@ -0,0 +1,181 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/circleci/cci-demo-docker/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Problems(t *testing.T) {
+	// SETUP: The handler rejects blank emails before the database is reached.
+	server := service.NewServer(nil)
+	get := func(accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/v1/contacts/%20", nil)
+		r.Header.Set(service.RequestIDHeader, "req-1234")
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: clients that accept problems, or anything
+	for _, accept := range []string{"", "*/*", "application/problem+json", "application/json;q=0.5, application/*"} {
+		w := get(accept)
+
+		// VERIFY:
+		require.Equal(t, http.StatusBadRequest, w.Code, accept)
+		assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"), accept)
+
+		var problem service.Problem
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+		assert.Equal(t, service.Problem{
+			Type:      "urn:contacts:problem:invalid_email",
+			Title:     "Bad Request",
+			Status:    http.StatusBadRequest,
+			Detail:    "Expected a single email.",
+			Instance:  "/v1/contacts/ ",
+			Code:      service.CodeInvalidEmail,
+			RequestID: "req-1234",
+		}, problem)
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: clients that prefer the legacy errors
+	for _, accept := range []string{"application/json", "application/json, */*;q=0.1", "application/problem+json;q=0.5, application/json"} {
+		w := get(accept)
+
+		// VERIFY:
+		require.Equal(t, http.StatusBadRequest, w.Code, accept)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"), accept)
+
+		var response service.ErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, service.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Expected a single email.", RequestID: "req-1234"}, response)
+	}
+}
+
+func Test_ClientProblems(t *testing.T) {
+	// SETUP: A server, and one that only writes the legacy errors.
+	server := httptest.NewServer(service.NewServer(nil))
+	defer server.Close()
+	legacy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"status_code": 404, "message": "Not Found"}`))
+	}))
+	defer legacy.Close()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`<h1>502 Bad Gateway</h1>`))
+	}))
+	defer proxy.Close()
+
+	// TEST:
+	_, invalidErr := service.NewClient(server.URL).GetContactByEmail(" ")
+	_, methodErr := service.NewClient(server.URL, service.WithAPIVersion("v9")).GetContactByEmail("alice@example.xyz")
+	_, legacyErr := service.NewClient(legacy.URL).GetContactByEmail("alice@example.xyz")
+	_, proxyErr := service.NewClient(proxy.URL).GetContactByEmail("alice@example.xyz")
+
+	// VERIFY: Errors match the sentinel errors for their codes, or status codes if the server didn't send a code.
+	require.IsType(t, service.ErrorResponse{}, invalidErr)
+	assert.Equal(t, "Expected a single email.", invalidErr.(service.ErrorResponse).Message)
+	assert.True(t, errors.Is(invalidErr, service.ErrInvalidEmail), "%v", invalidErr)
+	assert.True(t, errors.Is(invalidErr, service.ErrInvalidRequest), "%v", invalidErr)
+	assert.False(t, errors.Is(invalidErr, service.ErrNotFound), "%v", invalidErr)
+
+	assert.True(t, errors.Is(methodErr, service.ErrNotFound), "%v", methodErr)
+
+	assert.True(t, errors.Is(legacyErr, service.ErrNotFound), "%v", legacyErr)
+	assert.Equal(t, service.CodeNotFound, legacyErr.(service.ErrorResponse).Code)
+
+	// VERIFY: Responses without an error body are returned as a pointer, as they always have been.
+	require.IsType(t, &service.ErrorResponse{}, proxyErr)
+	assert.Equal(t, http.StatusBadGateway, proxyErr.(*service.ErrorResponse).StatusCode)
+	assert.Equal(t, "502 Bad Gateway", proxyErr.(*service.ErrorResponse).Message)
+	assert.True(t, errors.Is(proxyErr, service.ErrInternal), "%v", proxyErr)
+}
+
+// failingAuthenticator fails to authenticate every token, as if its key store were unreachable.
+type failingAuthenticator struct {
+	err error
+}
+
+func (a failingAuthenticator) Authenticate(ctx context.Context, token string) (*service.Principal, error) {
+	return nil, a.err
+}
+
+func Test_UnexpectedErrors(t *testing.T) {
+	// SETUP: A server whose authenticator fails, and one without a database, which panics when it is used.
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	failing := service.NewServer(nil, service.WithLogger(logger), service.WithAuthentication(failingAuthenticator{
+		err: errors.New("dial tcp 10.0.0.5:5432: connect: connection refused"),
+	}))
+	panicking := service.NewServer(nil, service.WithLogger(logger))
+	get := func(server *service.Server, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/v1/contacts/alice@example.xyz", nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	for secret, server := range map[string]*service.Server{"10.0.0.5": failing, "nil pointer": panicking} {
+		buf.Reset()
+
+		// TEST:
+		w := get(server, service.ProblemContentType)
+
+		// VERIFY: The client gets an incident ID, but not the error.
+		require.Equal(t, http.StatusInternalServerError, w.Code, secret)
+		assert.NotContains(t, w.Body.String(), secret)
+
+		var problem service.Problem
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+		assert.Equal(t, service.CodeInternal, problem.Code)
+		require.NotEmpty(t, problem.IncidentID)
+		assert.Equal(t, "An unexpected error occurred. Quote incident "+problem.IncidentID+" when reporting it.", problem.Detail)
+
+		// VERIFY: The error is logged in full under the incident ID.
+		var incident map[string]interface{}
+		for _, line := range logLines(t, &buf) {
+			if line["msg"] == "Unexpected error" {
+				incident = line
+			}
+		}
+		require.NotNil(t, incident, secret)
+		assert.Equal(t, problem.IncidentID, incident["incident_id"])
+		assert.Equal(t, problem.RequestID, incident["request_id"])
+		assert.Contains(t, incident["error"], secret)
+		assert.Equal(t, "/v1/contacts/:email", incident["route"])
+		assert.Equal(t, "/v1/contacts/alice@example.xyz", incident["path"])
+		assert.Contains(t, incident["stack"], "service.(*Server).writeUnexpectedError")
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: a client that prefers the legacy errors
+	{
+		w := get(failing, "application/json")
+
+		// VERIFY:
+		var response service.ErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		require.NotEmpty(t, response.IncidentID)
+		assert.Contains(t, response.Message, response.IncidentID)
+		assert.NotContains(t, response.Message, "10.0.0.5")
+	}
+}
@@ -0,0 +1,395 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Limit is a token bucket that allows Burst requests at once, and refills at Rate requests per Period.
+type Limit struct {
+	Rate   int
+	Period time.Duration
+	Burst  int
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%v/%v burst %v", l.Rate, l.Period, l.Burst)
+}
+
+// RateLimitResult is the outcome of taking a token from a bucket.
+type RateLimitResult struct {
+	Limit   Limit
+	Allowed bool
+	// Remaining is the number of whole tokens left in the bucket.
+	Remaining int
+	// RetryAfter is how long until a token is available, when the request wasn't allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// RateLimitStore holds the token buckets of a RateLimiter.
+type RateLimitStore interface {
+	// Take takes a token from the bucket with the given key, creating a full bucket if there is none.
+	Take(ctx context.Context, key string, limit Limit) (RateLimitResult, error)
+	// Refund returns a token taken from the bucket with the given key, for a request that turned out not to count
+	// against the limit.
+	Refund(ctx context.Context, key string, limit Limit) error
+}
+
+// bucket is the state of a token bucket at the time it was last updated.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// take refills b for the time elapsed since it was last updated, then takes a token if there is one. It returns the
+// updated bucket, and the time at which it will be full.
+func (l Limit) take(b bucket, now time.Time) (bucket, time.Time, RateLimitResult) {
+	perSecond := float64(l.Rate) / l.Period.Seconds()
+
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed < 0 {
+		elapsed = 0 // Clocks of different replicas may disagree slightly
+	}
+	tokens := math.Min(float64(l.Burst), b.tokens+elapsed*perSecond)
+
+	result := RateLimitResult{Limit: l}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - tokens) / perSecond)
+	}
+
+	result.Remaining = int(tokens)
+	result.Reset = secondsToDuration((float64(l.Burst) - tokens) / perSecond)
+
+	return bucket{tokens: tokens, updated: now}, now.Add(result.Reset), result
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// pruneEvery is how many tokens are taken from a store between removals of the buckets that are full again, which
+// behave the same as missing ones.
+const pruneEvery = 1024
+
+// ===== MEMORY STORE ==================================================================================================
+
+// MemoryRateLimitStore keeps token buckets in memory, so limits only apply to each replica separately.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	takes   int
+}
+
+type memoryBucket struct {
+	bucket
+	fullAt time.Time
+}
+
+// NewMemoryRateLimitStore creates an empty MemoryRateLimitStore.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*memoryBucket)}
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit Limit) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	s.takes++
+	if s.takes%pruneEvery == 0 {
+		for key, b := range s.buckets {
+			if b.fullAt.Before(now) {
+				delete(s.buckets, key)
+			}
+		}
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{bucket: bucket{tokens: float64(limit.Burst), updated: now}}
+		s.buckets[key] = b
+	}
+
+	var result RateLimitResult
+	b.bucket, b.fullAt, result = limit.take(b.bucket, now)
+	return result, nil
+}
+
+func (s *MemoryRateLimitStore) Refund(ctx context.Context, key string, limit Limit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if b, ok := s.buckets[key]; ok {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+1)
+	}
+
+	return nil
+}
+
+// ===== POSTGRES STORE ================================================================================================
+
+// PostgresRateLimitStore keeps token buckets in the rate_limit_buckets table, so that limits hold across replicas.
+// The database's clock is used, so that replicas agree on how much each bucket has refilled.
+type PostgresRateLimitStore struct {
+	db *Database
+
+	mu    sync.Mutex
+	takes int
+}
+
+// NewPostgresRateLimitStore creates a PostgresRateLimitStore using db.
+func NewPostgresRateLimitStore(db *Database) *PostgresRateLimitStore {
+	return &PostgresRateLimitStore{db: db}
+}
+
+func (s *PostgresRateLimitStore) Take(ctx context.Context, key string, limit Limit) (RateLimitResult, error) {
+	s.mu.Lock()
+	s.takes++
+	prune := s.takes%pruneEvery == 0
+	s.mu.Unlock()
+
+	var result RateLimitResult
+	err := s.db.Write(ctx, func(tx *Transaction) {
+		if prune {
+			tx.PruneRateLimitBuckets()
+		}
+		result = tx.TakeRateLimitToken(key, limit)
+	})
+
+	return result, err
+}
+
+func (s *PostgresRateLimitStore) Refund(ctx context.Context, key string, limit Limit) error {
+	return s.db.Write(ctx, func(tx *Transaction) {
+		tx.RefundRateLimitToken(key, limit)
+	})
+}
+
+// TakeRateLimitToken takes a token from the bucket with the given key within the transaction. The bucket is locked
+// until the transaction ends, so concurrent requests by the same client are counted one after the other.
+func (tx *Transaction) TakeRateLimitToken(key string, limit Limit) RateLimitResult {
+	_, err := tx.Exec(
+		"INSERT INTO rate_limit_buckets (key, tokens, updated_at, full_at) VALUES ($1, $2, clock_timestamp(), clock_timestamp()) ON CONFLICT (key) DO NOTHING",
+		key,
+		float64(limit.Burst),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	var b bucket
+	var now time.Time
+	row := tx.QueryRow("SELECT tokens, updated_at, clock_timestamp() FROM rate_limit_buckets WHERE key = $1 FOR UPDATE", key)
+	if err := row.Scan(&b.tokens, &b.updated, &now); err != nil {
+		panic(err)
+	}
+
+	b, fullAt, result := limit.take(b, now)
+	_, err = tx.Exec(
+		"UPDATE rate_limit_buckets SET tokens = $2, updated_at = $3, full_at = $4 WHERE key = $1",
+		key,
+		b.tokens,
+		b.updated,
+		fullAt,
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	return result
+}
+
+// RefundRateLimitToken returns a token to the bucket with the given key within the transaction. The bucket is left
+// to be pruned when it would have been without the refund.
+func (tx *Transaction) RefundRateLimitToken(key string, limit Limit) {
+	_, err := tx.Exec("UPDATE rate_limit_buckets SET tokens = LEAST(tokens + 1, $2) WHERE key = $1", key, float64(limit.Burst))
+	if err != nil {
+		panic(err)
+	}
+}
+
+// PruneRateLimitBuckets deletes the buckets that have refilled completely, which behave the same as missing ones.
+func (tx *Transaction) PruneRateLimitBuckets() {
+	if _, err := tx.Exec("DELETE FROM rate_limit_buckets WHERE full_at < clock_timestamp()"); err != nil {
+		panic(err)
+	}
+}
+
+// ===== MIDDLEWARE ====================================================================================================
+
+// RateLimiter limits the rate of requests from each client to each route.
+type RateLimiter struct {
+	Store RateLimitStore
+	// Default applies to every route of the API that isn't in Routes. A zero Rate disables it.
+	Default Limit
+	// Routes are keyed by method and route pattern, e.g. "POST /v1/contacts". Routes outside the API, like /healthz,
+	// are only limited when they are listed. Unversioned aliases, like /contacts, share the limit of their v1 route.
+	Routes map[string]Limit
+}
+
+// WithRateLimiter limits the rate of requests. The server panics when created if a route in the RateLimiter's Routes
+// doesn't exist, to catch typos in configuration.
+func WithRateLimiter(limiter *RateLimiter) ServerOption {
+	return func(s *Server) {
+		s.rateLimiter = limiter
+	}
+}
+
+// ClientKey identifies the client making r, so that each client is rate limited separately. Authenticated clients are
+// identified by their principal, e.g. their API key, and others by their IP address; requests forwarded by a proxy are
+// attributed to the proxy.
+func ClientKey(r *http.Request) string {
+	if principal := PrincipalFromContext(r.Context()); principal != nil {
+		return principal.ID
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return "ip:" + host
+}
+
+// checkRateLimits panics if a route in the RateLimiter's Routes isn't served. Aliases can't be given limits of their own,
+// as they share their canonical route's.
+func (s *Server) checkRateLimits() {
+	if s.rateLimiter == nil {
+		return
+	}
+
+	served := map[string]bool{}
+	for _, route := range s.routes {
+		served[route.Method+" "+route.Canonical] = true
+	}
+	for route := range s.rateLimiter.Routes {
+		if !served[route] {
+			panic(fmt.Sprintf("Rate limit configured for unknown route %q", route))
+		}
+	}
+}
+
+// rateLimit wraps the handler of a route to enforce its rate limit, if it has one. path is the route's canonical path,
+// which its limit and buckets are keyed by.
+func (s *Server) rateLimit(method string, path string, api bool, handler httprouter.Handle) httprouter.Handle {
+	if s.rateLimiter == nil {
+		return handler
+	}
+
+	route := method + " " + path
+	limit, ok := s.rateLimiter.Routes[route]
+	if !ok && api {
+		limit = s.rateLimiter.Default
+	}
+	if limit.Rate <= 0 {
+		return handler
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		result, err := s.rateLimiter.Store.Take(r.Context(), route+" "+ClientKey(r), limit)
+		if err != nil {
+			// Failing open keeps the service available if the store is, at the cost of not limiting for a while
+			s.logger.ErrorContext(r.Context(), "Unable to check rate limit, allowing the request", "error", err.Error())
+			handler(w, r, ps)
+			return
+		}
+
+		setRateLimitHeaders(w.Header(), result)
+		if !result.Allowed {
+			writeRateLimited(w, r, result)
+			return
+		}
+
+		handler(w, r, ps)
+	}
+}
+
+// DefaultAuthenticationLimit is the limit of failed authentications from each IP address, unless changed with
+// WithAuthenticationLimit.
+var DefaultAuthenticationLimit = Limit{Rate: 20, Period: time.Minute, Burst: 20}
+
+// WithAuthenticationLimit limits the failed authentications from each IP address, to any route, keeping their buckets
+// in store. Once the limit is exceeded, the address's requests are rejected before their tokens are looked up, so that
+// guessing tokens can't load the stores they're looked up in. A zero Rate disables it. By default, it is
+// DefaultAuthenticationLimit, kept in memory.
+func WithAuthenticationLimit(store RateLimitStore, limit Limit) ServerOption {
+	return func(s *Server) {
+		s.authLimitStore = store
+		s.authLimit = limit
+	}
+}
+
+// authenticationKey is the key of the bucket charged for the failed authentications of the client making r, which
+// has no principal yet, so is identified by its IP address.
+func authenticationKey(r *http.Request) string {
+	return "authentication " + ClientKey(r)
+}
+
+// takeAuthenticationToken takes a token from the bucket of the client making r before it is authenticated, so that
+// concurrent guesses can't all be looked up before any of them is charged. It writes a 429 and returns false if there
+// is none. The token is kept if authentication fails, and should be returned with refundAuthenticationToken otherwise.
+func (s *Server) takeAuthenticationToken(w http.ResponseWriter, r *http.Request) bool {
+	if s.authLimit.Rate <= 0 {
+		return true
+	}
+
+	result, err := s.authLimitStore.Take(r.Context(), authenticationKey(r), s.authLimit)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "Unable to check rate limit, allowing the request", "error", err.Error())
+		return true
+	}
+	if !result.Allowed {
+		setRateLimitHeaders(w.Header(), result)
+		writeRateLimited(w, r, result)
+		return false
+	}
+
+	return true
+}
+
+// refundAuthenticationToken returns the token taken by takeAuthenticationToken, as the client authenticated, or
+// authentication failed through no fault of its own.
+func (s *Server) refundAuthenticationToken(r *http.Request) {
+	if s.authLimit.Rate <= 0 {
+		return
+	}
+
+	if err := s.authLimitStore.Refund(r.Context(), authenticationKey(r), s.authLimit); err != nil {
+		s.logger.ErrorContext(r.Context(), "Unable to refund rate limit token", "error", err.Error())
+	}
+}
+
+// writeRateLimited writes a 429 telling the client when it can retry.
+func writeRateLimited(w http.ResponseWriter, r *http.Request, result RateLimitResult) {
+	retryAfter := ceilSeconds(result.RetryAfter)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	writeJSONError(w, r, http.StatusTooManyRequests, fmt.Sprintf("Rate limit exceeded, retry in %v seconds", retryAfter))
+}
+
+// setRateLimitHeaders describes the client's limit with the RateLimit headers of
+// https://datatracker.ietf.org/doc/draft-ietf-httpapi-ratelimit-headers/.
+func setRateLimitHeaders(header http.Header, result RateLimitResult) {
+	limit := result.Limit
+	header.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	header.Set("RateLimit-Policy", fmt.Sprintf("%v;w=%v;burst=%v", limit.Rate, ceilSeconds(limit.Period), limit.Burst))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,272 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/circleci/cci-demo-docker/service"
+	"github.com/circleci/cci-demo-docker/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MemoryRateLimitStore(t *testing.T) {
+	// SETUP: A limit allowing 2 requests at once, refilling slowly enough not to refill during the test.
+	store := service.NewMemoryRateLimitStore()
+	limit := service.Limit{Rate: 1, Period: time.Hour, Burst: 2}
+	ctx := context.Background()
+
+	// TEST: Taking more tokens than the burst.
+	first, err := store.Take(ctx, "alice", limit)
+	require.NoError(t, err)
+	second, err := store.Take(ctx, "alice", limit)
+	require.NoError(t, err)
+	third, err := store.Take(ctx, "alice", limit)
+	require.NoError(t, err)
+
+	// VERIFY: The burst is allowed, and the next request has to wait for a token to refill.
+	assert.True(t, first.Allowed)
+	assert.Equal(t, 1, first.Remaining)
+	assert.True(t, second.Allowed)
+	assert.Equal(t, 0, second.Remaining)
+	assert.False(t, third.Allowed)
+	assert.InDelta(t, time.Hour.Seconds(), third.RetryAfter.Seconds(), 1)
+	assert.InDelta(t, (2 * time.Hour).Seconds(), third.Reset.Seconds(), 1)
+
+	// VERIFY: Other clients have their own bucket.
+	other, err := store.Take(ctx, "bob", limit)
+	require.NoError(t, err)
+	assert.True(t, other.Allowed)
+}
+
+func Test_PostgresRateLimitStore(t *testing.T) {
+	// SETUP: A key unique to the test, as buckets outlive it.
+	env := test.SetupEnv(t)
+	defer env.Close()
+	store := service.NewPostgresRateLimitStore(env.DB)
+	limit := service.Limit{Rate: 1, Period: time.Hour, Burst: 1}
+	key := "test:" + service.NewRequestID()
+
+	// TEST: Exceeding the limit.
+	first, err := store.Take(context.Background(), key, limit)
+	require.NoError(t, err)
+	second, err := store.Take(context.Background(), key, limit)
+	require.NoError(t, err)
+
+	// VERIFY:
+	assert.True(t, first.Allowed)
+	assert.False(t, second.Allowed)
+	assert.InDelta(t, time.Hour.Seconds(), second.RetryAfter.Seconds(), 1)
+}
+
+func Test_RateLimit(t *testing.T) {
+	// SETUP: A single request a minute to /healthz, which isn't limited by default as it's outside the API.
+	server := service.NewServer(nil, service.WithRateLimiter(&service.RateLimiter{
+		Store:  service.NewMemoryRateLimitStore(),
+		Routes: map[string]service.Limit{"GET /healthz": {Rate: 1, Period: time.Minute, Burst: 1}},
+	}))
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	// TEST: Exceeding the limit.
+	allowed := get("/healthz")
+	limited := get("/healthz")
+
+	// VERIFY: Both responses describe the limit, and the second one says when to retry.
+	require.Equal(t, http.StatusOK, allowed.Code)
+	assert.Equal(t, "1", allowed.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", allowed.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", allowed.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "1;w=60;burst=1", allowed.Header().Get("RateLimit-Policy"))
+
+	require.Equal(t, http.StatusTooManyRequests, limited.Code)
+	assert.Equal(t, "60", limited.Header().Get("Retry-After"))
+	assert.Equal(t, "0", limited.Header().Get("RateLimit-Remaining"))
+
+	var body service.Problem
+	require.NoError(t, json.Unmarshal(limited.Body.Bytes(), &body))
+	assert.Equal(t, http.StatusTooManyRequests, body.Status)
+	assert.Equal(t, service.CodeRateLimited, body.Code)
+	assert.Equal(t, "Rate limit exceeded, retry in 60 seconds", body.Detail)
+
+	// VERIFY: Routes without a limit aren't limited.
+	metrics := get("/metrics")
+	assert.Equal(t, http.StatusOK, metrics.Code)
+	assert.Empty(t, metrics.Header().Get("RateLimit-Limit"))
+}
+
+// countingAuthenticator counts the tokens it's asked to authenticate.
+type countingAuthenticator struct {
+	staticAuthenticator
+	calls *int32
+}
+
+func (a countingAuthenticator) Authenticate(ctx context.Context, token string) (*service.Principal, error) {
+	atomic.AddInt32(a.calls, 1)
+	return a.staticAuthenticator.Authenticate(ctx, token)
+}
+
+func Test_RateLimitFailedAuthentication(t *testing.T) {
+	// SETUP: Two failed authentications at once from each address. Routes aren't limited otherwise.
+	var calls int32
+	server := service.NewServer(nil,
+		service.WithAuthentication(countingAuthenticator{
+			staticAuthenticator: staticAuthenticator{token: "secret", principal: &service.Principal{ID: "test:alice", Scopes: []string{"contacts:read"}}},
+			calls:               &calls,
+		}),
+		service.WithAuthenticationLimit(service.NewMemoryRateLimitStore(), service.Limit{Rate: 1, Period: time.Minute, Burst: 2}),
+	)
+	get := func(remoteAddr string, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/v1/contacts/%20", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	// TEST: Guessing tokens.
+	var codes []int
+	for i := 0; i < 4; i++ {
+		codes = append(codes, get("192.0.2.1:1234", "ck_guess_"+strconv.Itoa(i)).Code)
+	}
+
+	// VERIFY: The guesses are rejected without being looked up once the limit is exceeded.
+	assert.Equal(t, []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusTooManyRequests}, codes)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// VERIFY: Clients at other addresses can still authenticate. The blank email is rejected after authentication.
+	assert.Equal(t, http.StatusBadRequest, get("192.0.2.2:1234", "secret").Code)
+	assert.Equal(t, http.StatusBadRequest, get("192.0.2.2:1234", "secret").Code)
+	assert.Equal(t, http.StatusBadRequest, get("192.0.2.2:1234", "secret").Code, "Successful authentications aren't charged")
+}
+
+// blockingAuthenticator rejects every token, once it is released.
+type blockingAuthenticator struct {
+	release chan struct{}
+}
+
+func (a blockingAuthenticator) Authenticate(ctx context.Context, token string) (*service.Principal, error) {
+	<-a.release
+	return nil, service.ErrInvalidToken
+}
+
+func Test_RateLimitConcurrentFailedAuthentication(t *testing.T) {
+	// SETUP: The default limit, which applies without a RateLimiter, and an authenticator that holds every guess until
+	// it is released.
+	release := make(chan struct{})
+	server := service.NewServer(nil, service.WithAuthentication(blockingAuthenticator{release: release}))
+	guesses := service.DefaultAuthenticationLimit.Burst + 5
+
+	// TEST: Guessing tokens concurrently.
+	codes := make(chan int, guesses)
+	for i := 0; i < guesses; i++ {
+		go func(i int) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/contacts/%20", nil)
+			r.Header.Set("Authorization", "Bearer ck_guess_"+strconv.Itoa(i))
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, r)
+			codes <- w.Code
+		}(i)
+	}
+
+	// VERIFY: The guesses beyond the burst are rejected while the others are still being looked up.
+	for i := 0; i < 5; i++ {
+		select {
+		case code := <-codes:
+			assert.Equal(t, http.StatusTooManyRequests, code)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Guesses beyond the burst were looked up")
+		}
+	}
+	close(release)
+	for i := 5; i < guesses; i++ {
+		assert.Equal(t, http.StatusUnauthorized, <-codes)
+	}
+}
+
+func Test_RateLimitAlias(t *testing.T) {
+	// SETUP: Two requests a minute to the v1 route. Every request is rejected by the handler before the database.
+	server := service.NewServer(nil, service.WithRateLimiter(&service.RateLimiter{
+		Store:  service.NewMemoryRateLimitStore(),
+		Routes: map[string]service.Limit{"GET /v1/contacts/:email": {Rate: 2, Period: time.Minute, Burst: 2}},
+	}))
+
+	// TEST: Alternating between the unversioned alias and the v1 route.
+	var codes []int
+	for _, path := range []string{"/contacts/%20", "/v1/contacts/%20", "/contacts/%20", "/v1/contacts/%20"} {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		codes = append(codes, w.Code)
+	}
+
+	// VERIFY: Both paths share the v1 route's limit and bucket.
+	assert.Equal(t, []int{http.StatusBadRequest, http.StatusBadRequest, http.StatusTooManyRequests, http.StatusTooManyRequests}, codes)
+}
+
+func Test_RateLimitUnknownRoute(t *testing.T) {
+	// Unknown routes, and aliases, which share the limit of their v1 route.
+	for _, route := range []string{"GET /v1/contact", "GET /contacts/:email"} {
+		assert.Panics(t, func() {
+			service.NewServer(nil, service.WithRateLimiter(&service.RateLimiter{
+				Store:  service.NewMemoryRateLimitStore(),
+				Routes: map[string]service.Limit{route: {Rate: 1, Period: time.Minute, Burst: 1}},
+			}))
+		}, route)
+	}
+}
+
+func Test_ClientHonoursRetryAfter(t *testing.T) {
+	// SETUP: A service that rate limits every other request.
+	var requests int32
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if atomic.AddInt32(&requests, 1)%2 == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"status_code":429,"message":"Rate limit exceeded, retry in 1 seconds"}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"contact":{"id":1,"email":"alice@example.xyz","name":"Alice Zulu"}}`))
+	}))
+	defer httpServer.Close()
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: when the service asks to wait less than the client's maximum
+	{
+		client := service.NewClient(httpServer.URL)
+		start := time.Now()
+
+		contact, err := client.GetContactByEmail("alice@example.xyz")
+
+		// VERIFY: The request is retried after waiting.
+		require.NoError(t, err)
+		assert.Equal(t, "alice@example.xyz", contact.Email)
+		assert.True(t, time.Since(start) >= time.Second, "The client didn't wait before retrying")
+		assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+	}
+
+	// -------------------------------------------------------------------------------------------------------------
+	// TEST: when the service asks to wait longer than the client's maximum
+	{
+		client := service.NewClient(httpServer.URL, service.WithRetries(2, time.Millisecond))
+
+		_, err := client.GetContactByEmail("alice@example.xyz")
+
+		// VERIFY: The error says how long to wait.
+		require.IsType(t, service.ErrorResponse{}, err)
+		assert.Equal(t, http.StatusTooManyRequests, err.(service.ErrorResponse).StatusCode)
+		assert.Equal(t, time.Second, err.(service.ErrorResponse).RetryAfter)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+	}
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// DefaultAPIVersion is the API version that unversioned paths are aliases of, and that clients use by default.
+const DefaultAPIVersion = "v1"
+
+// Default deprecation dates for the unversioned aliases of the v1 routes, used unless changed with
+// WithLegacyDeprecation and WithLegacySunset. The aliases were deprecated when /v1 was introduced.
+var (
+	DefaultLegacyDeprecation = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+	DefaultLegacySunset      = time.Date(2027, time.April, 18, 0, 0, 0, 0, time.UTC)
+)
+
+// apiRoute is a route of the versioned API, with the handler for each version that changed it.
+type apiRoute struct {
+	method     string
+	path       string
+	permission Permission
+	handlers   map[string]httprouter.Handle
+}
+
+// handle registers a route of the v1 API, which is also served by every later version unless overridden.
+func (s *Server) handle(method string, path string, permission Permission, handler httprouter.Handle) {
+	s.handleVersion(DefaultAPIVersion, method, path, permission, handler)
+}
+
+// handleVersion registers the handler for a route from the given version of the API onwards, for example to change
+// the response of a single route in v2 while every other route is served by its v1 handler. The permission of a route
+// is the same in every version.
+func (s *Server) handleVersion(version string, method string, path string, permission Permission, handler httprouter.Handle) {
+	apiVersionNumber(version) // Panics if the version is invalid
+
+	for _, route := range s.apiRoutes {
+		if route.method == method && route.path == path {
+			if route.permission != permission {
+				panic(fmt.Sprintf("The permission of %v %v can't change in %v", method, path, version))
+			}
+			route.handlers[version] = handler
+			return
+		}
+	}
+
+	s.apiRoutes = append(s.apiRoutes, &apiRoute{
+		method:     method,
+		path:       path,
+		permission: permission,
+		handlers:   map[string]httprouter.Handle{version: handler},
+	})
+}
+
+// mountAPI adds every API route to the router, under a prefix for each version in use, e.g. /v1/contacts. The v1
+// routes are also mounted without a prefix, as deprecated aliases.
+func (s *Server) mountAPI() {
+	versions := map[int]string{}
+	latest := 0
+	for _, route := range s.apiRoutes {
+		for version := range route.handlers {
+			n := apiVersionNumber(version)
+			versions[n] = version
+			if n > latest {
+				latest = n
+			}
+		}
+	}
+
+	for n := 1; n <= latest; n++ {
+		version, ok := versions[n]
+		if !ok {
+			continue
+		}
+
+		for _, route := range s.apiRoutes {
+			if handler := route.handlerFor(n); handler != nil {
+				path := "/" + version + route.path
+				s.addRoute(route.method, path, path, route.permission, true, handler)
+			}
+		}
+	}
+
+	for _, route := range s.apiRoutes {
+		if handler := route.handlerFor(1); handler != nil {
+			canonical := "/" + DefaultAPIVersion + route.path
+			s.addRoute(route.method, route.path, canonical, route.permission, true, s.deprecatedAlias(route.path, handler))
+		}
+	}
+}
+
+// route adds a handler for a route outside the API to the router.
+func (s *Server) route(method string, path string, permission Permission, handler httprouter.Handle) {
+	s.addRoute(method, path, path, permission, false, handler)
+}
+
+// addRoute adds a handler to the router, recording it so that Routes can list every route that is served. Routes that
+// aren't Public require authentication and their permission's scope. Routes of the API get the default rate limit.
+// Aliases are rate limited as their canonical path, so that they share its limit and its clients' buckets.
+func (s *Server) addRoute(method string, path string, canonical string, permission Permission, api bool, handler httprouter.Handle) {
+	handler = s.authenticate(permission, s.authorize(permission, s.rateLimit(method, canonical, api, handler)))
+	s.router.Handle(method, path, withRoute(path, handler))
+	s.routes = append(s.routes, Route{Method: method, Path: path, Canonical: canonical, Permission: permission})
+}
+
+// Route is a method and path served by the Server. Path uses the router's syntax, e.g. /v1/contacts/:email.
+type Route struct {
+	Method string
+	Path   string
+	// Canonical is the path of the route that Path is an alias of, e.g. /v1/contacts for /contacts, or Path if it
+	// isn't an alias.
+	Canonical  string
+	Permission Permission
+}
+
+// Routes lists every route served by the Server, in the order they were added.
+func (s *Server) Routes() []Route {
+	return append([]Route(nil), s.routes...)
+}
+
+// handlerFor returns the handler of the newest version of the route that isn't newer than version n, or nil if the
+// route was added after n.
+func (route *apiRoute) handlerFor(n int) httprouter.Handle {
+	var handler httprouter.Handle
+	handlerVersion := 0
+	for version, h := range route.handlers {
+		if v := apiVersionNumber(version); v <= n && v > handlerVersion {
+			handler, handlerVersion = h, v
+		}
+	}
+
+	return handler
+}
+
+// deprecatedAlias wraps the handler of an unversioned path, to tell clients to move to the versioned one.
+func (s *Server) deprecatedAlias(path string, handler httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", s.deprecatedAt.Unix()))
+		w.Header().Set("Sunset", s.legacySunset.UTC().Format(http.TimeFormat))
+		w.Header().Set("Link", fmt.Sprintf(`</%v%v>; rel="successor-version"`, DefaultAPIVersion, path))
+		handler(w, r, ps)
+	}
+}
+
+// apiVersionNumber parses versions like "v2".
+func apiVersionNumber(version string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(version, "v"))
+	if !strings.HasPrefix(version, "v") || err != nil || n < 1 {
+		panic(fmt.Sprintf("Invalid API version %q", version))
+	}
+
+	return n
+}
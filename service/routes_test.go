@@ -0,0 +1,49 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+func respondWith(body string) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Write([]byte(body))
+	}
+}
+
+func Test_APIVersioning(t *testing.T) {
+	// SETUP: A v2 override of a single route.
+	s := &Server{
+		router:       httprouter.New(),
+		deprecatedAt: time.Date(2029, time.January, 1, 0, 0, 0, 0, time.UTC),
+		legacySunset: time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+	s.handle(http.MethodGet, "/things", ReadContacts, respondWith("v1 things"))
+	s.handle(http.MethodGet, "/others", ReadContacts, respondWith("v1 others"))
+	s.handleVersion("v2", http.MethodGet, "/things", ReadContacts, respondWith("v2 things"))
+	s.mountAPI()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	// VERIFY: Each version uses its own handler, falling back to older versions.
+	assert.Equal(t, "v1 things", get("/v1/things").Body.String())
+	assert.Equal(t, "v2 things", get("/v2/things").Body.String())
+	assert.Equal(t, "v1 others", get("/v2/others").Body.String())
+	assert.Empty(t, get("/v1/things").Header().Get("Deprecation"))
+
+	// VERIFY: Unversioned paths are deprecated aliases of v1.
+	legacy := get("/things")
+	assert.Equal(t, "v1 things", legacy.Body.String())
+	assert.Equal(t, "@1861920000", legacy.Header().Get("Deprecation"))
+	assert.Equal(t, "Tue, 01 Jan 2030 00:00:00 GMT", legacy.Header().Get("Sunset"))
+	assert.Equal(t, `</v1/things>; rel="successor-version"`, legacy.Header().Get("Link"))
+}
@@ -2,31 +2,103 @@ package service
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
 
 // NewServer initializes the service with the given Database, and sets up appropriate routes.
-func NewServer(db *Database) *Server {
+func NewServer(db *Database, options ...ServerOption) *Server {
 	router := httprouter.New()
 	server := &Server{
-		router: router,
-		db:     db,
+		Readiness:    NewReadiness(),
+		router:       router,
+		db:           db,
+		legacySunset: DefaultLegacySunset,
+		deprecatedAt: DefaultLegacyDeprecation,
+		maxBodySize:  DefaultMaxBodySize,
+		logger:       newLogger(nil),
+
+		// Failed authentications are limited even when requests aren't, see WithAuthenticationLimit
+		authLimitStore: NewMemoryRateLimitStore(),
+		authLimit:      DefaultAuthenticationLimit,
+	}
+
+	for _, option := range options {
+		option(server)
 	}
 
 	server.setupRoutes()
 	return server
 }
 
+// ServerOption configures a Server created by NewServer.
+type ServerOption func(*Server)
+
+// WithLegacyDeprecation sets the date sent in the Deprecation header of unversioned paths, from which clients should
+// use the versioned ones instead.
+func WithLegacyDeprecation(deprecation time.Time) ServerOption {
+	return func(s *Server) {
+		s.deprecatedAt = deprecation
+	}
+}
+
+// WithLegacySunset sets the date sent in the Sunset header of unversioned paths, after which they may be removed.
+func WithLegacySunset(sunset time.Time) ServerOption {
+	return func(s *Server) {
+		s.legacySunset = sunset
+	}
+}
+
+// WithLogger sets the logger for the access log and errors, instead of the default logger. The ID of the request is
+// added to every record logged with the request's context.
+func WithLogger(logger *slog.Logger) ServerOption {
+	return func(s *Server) {
+		s.logger = newLogger(logger)
+	}
+}
+
+// Logger returns the logger of the access log and errors, for things serving alongside the server to log with, like a
+// CertReloader.
+func (s *Server) Logger() *slog.Logger {
+	return s.logger
+}
+
+// WithRequestValidation rejects requests that don't match the OpenAPI spec with a 400, or a 415 if their Content-Type
+// isn't documented, before they reach a handler.
+func WithRequestValidation() ServerOption {
+	return func(s *Server) {
+		s.spec = DefaultSpec()
+	}
+}
+
 // Server contains all that is needed to respond to incoming requests, like a database. Other services like a mail,
 // redis, or S3 server could also be added.
 type Server struct {
-	router *httprouter.Router
-	db     *Database
+	// Readiness decides whether the /readyz route reports the server as ready for traffic.
+	Readiness *Readiness
+
+	router       *httprouter.Router
+	db           *Database
+	apiRoutes    []*apiRoute
+	routes       []Route
+	legacySunset time.Time
+	deprecatedAt time.Time
+	spec         *Spec
+	logger       *slog.Logger
+	rateLimiter  *RateLimiter
+	cors         *CORS
+	maxBodySize  int64
+
+	authenticators []Authenticator
+	authLimitStore RateLimitStore
+	authLimit      Limit
 }
 
 // The ServerError type allows errors to provide an appropriate HTTP status code and message. The Server checks for
@@ -37,54 +109,89 @@ type ServerError interface {
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.router.ServeHTTP(w, r)
+	s.accessLog(w, r, func(w http.ResponseWriter, r *http.Request) {
+		s.traceRequest(w, r, func(w http.ResponseWriter, r *http.Request) {
+			if s.cors != nil && s.cors.handle(w, r) {
+				return
+			}
+
+			if s.spec != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, s.maxBodySize)
+				var tooLarge *http.MaxBytesError
+				var decodeErr *DecodeError
+				if err := s.spec.ValidateRequest(r); errors.As(err, &tooLarge) {
+					writeJSONError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body must not be larger than %v bytes", tooLarge.Limit))
+					return
+				} else if errors.As(err, &decodeErr) {
+					writeServerError(w, r, decodeErr)
+					return
+				} else if err != nil {
+					writeJSONError(w, r, http.StatusBadRequest, err.Error())
+					return
+				}
+			}
+
+			s.router.ServeHTTP(w, r)
+		})
+	})
 }
 
 func (s *Server) setupRoutes() {
-	s.router.POST("/contacts", s.AddContact)
-	s.router.GET("/contacts/:email", s.GetContactByEmail)
+	// API routes are served under a prefix for each version, e.g. /v1/contacts. Use handleVersion to change a route in
+	// a new version of the API. Every route declares the Permission needed to use it: ReadContacts for GET routes,
+	// WriteContacts for POST, PUT and DELETE, and AdminContacts for bulk operations like purging or merging contacts.
+	s.handle(http.MethodPost, "/contacts", WriteContacts, s.AddContact)
+	s.handle(http.MethodGet, "/contacts/:email", ReadContacts, s.GetContactByEmail)
+	s.mountAPI()
+
+	s.route(http.MethodGet, "/healthz", Public, s.Healthz)
+	s.route(http.MethodGet, "/readyz", Public, s.Readyz)
+	s.route(http.MethodGet, "/openapi.json", Public, s.OpenAPI)
+	s.route(http.MethodGet, "/metrics", Public, s.Metrics)
+	s.checkRateLimits()
 
 	// By default the router will handle errors. But the service should always return JSON if possible, so these
 	// custom handlers are added.
 
 	s.router.NotFound = http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
-			writeJSONError(w, http.StatusNotFound, "")
+			writeJSONError(w, r, http.StatusNotFound, "")
 		},
 	)
 
 	s.router.HandleMethodNotAllowed = true
 	s.router.MethodNotAllowed = http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
-			writeJSONError(w, http.StatusMethodNotAllowed, "")
+			writeJSONError(w, r, http.StatusMethodNotAllowed, "")
 		},
 	)
 
 	s.router.PanicHandler = func(w http.ResponseWriter, r *http.Request, e interface{}) {
+		httpPanicsRecovered.With(routePattern(r)).Inc()
+
 		serverError, ok := e.(ServerError)
 		if ok {
-			writeJSONError(w, serverError.HttpStatusCode(), serverError.HttpStatusMessage())
+			writeServerError(w, r, serverError)
+		} else if err, ok := e.(error); ok {
+			s.writeUnexpectedError(w, r, fmt.Errorf("Panic during request: %w", err))
 		} else {
-			log.Printf("Panic during request: %v", e)
-			writeJSONError(w, http.StatusInternalServerError, "")
+			s.writeUnexpectedError(w, r, fmt.Errorf("Panic during request: %v", e))
 		}
 	}
 }
 
 // AddContact handles HTTP requests to add a Contact.
 func (s *Server) AddContact(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	var contact Contact
-
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&contact); err != nil {
-		writeJSONError(w, http.StatusBadRequest, "Error decoding JSON")
+	var request AddContactRequest
+	if err := s.decodeJSON(w, r, &request); err != nil {
+		writeServerError(w, r, err)
 		return
 	}
+	contact := Contact{Email: request.Email, Name: request.Name}
 
-	contactId, err := s.db.AddContact(contact)
+	contactId, err := s.db.AddContact(r.Context(), contact)
 	if err != nil {
 		panic(err)
-		return
 	}
 	contact.Id = contactId
 
@@ -101,21 +208,21 @@ func (s *Server) AddContact(w http.ResponseWriter, r *http.Request, ps httproute
 func (s *Server) GetContactByEmail(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	email, err := url.QueryUnescape(ps.ByName("email"))
 	if err != nil {
-		writeJSONError(w, http.StatusBadRequest, "Invalid email.")
+		writeProblem(w, r, &Problem{Status: http.StatusBadRequest, Code: CodeInvalidEmail, Detail: "Invalid email."})
 		return
 	}
 
 	email = strings.TrimSpace(email)
 	if email == "" {
-		writeJSONError(w, http.StatusBadRequest, "Expected a single email.")
+		writeProblem(w, r, &Problem{Status: http.StatusBadRequest, Code: CodeInvalidEmail, Detail: "Expected a single email."})
 		return
 	}
 
-	contact, err := s.db.GetContactByEmail(email)
+	contact, err := s.db.GetContactByEmail(r.Context(), email)
 	if err != nil {
-		writeUnexpectedError(w, err)
+		s.writeUnexpectedError(w, r, err)
 	} else if contact == nil {
-		writeJSONNotFound(w)
+		writeJSONNotFound(w, r)
 	} else {
 		writeJSON(
 			w,
@@ -137,25 +244,11 @@ func writeJSON(w http.ResponseWriter, statusCode int, response interface{}) {
 	encoder.Encode(response)
 }
 
-func writeJSONError(w http.ResponseWriter, statusCode int, message string) {
-	if message == "" {
-		message = http.StatusText(statusCode)
-	}
-
-	writeJSON(
-		w,
-		statusCode,
-		&ErrorResponse{
-			StatusCode: statusCode,
-			Message:    message,
-		},
-	)
-}
-
-func writeJSONNotFound(w http.ResponseWriter) {
-	writeJSONError(w, http.StatusNotFound, "")
+// writeJSONError writes an error response with the code for its status code. See writeProblem.
+func writeJSONError(w http.ResponseWriter, r *http.Request, statusCode int, message string) {
+	writeProblem(w, r, &Problem{Status: statusCode, Detail: message})
 }
 
-func writeUnexpectedError(w http.ResponseWriter, err error) {
-	writeJSONError(w, http.StatusInternalServerError, err.Error())
+func writeJSONNotFound(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, r, http.StatusNotFound, "")
 }
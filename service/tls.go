@@ -0,0 +1,182 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// ===== CERTIFICATE RELOADING =========================================================================================
+
+// CertReloader serves a certificate and key loaded from files, and reloads them when either file changes. This allows
+// certificates to be rotated without restarting the server.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	logger   *slog.Logger
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+
+	stop chan struct{}
+}
+
+// NewCertReloader loads the certificate and key, then checks the files for changes every interval until Close is
+// called. Reloads are logged with logger, or the default logger if it is nil, e.g. the Server's Logger.
+func NewCertReloader(certFile, keyFile string, interval time.Duration, logger *slog.Logger) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   newLogger(logger),
+		stop:     make(chan struct{}),
+	}
+
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	go r.watch(interval)
+	return r, nil
+}
+
+// GetCertificate returns the current certificate. It is intended for tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Close stops watching the files for changes.
+func (r *CertReloader) Close() {
+	close(r.stop)
+}
+
+func (r *CertReloader) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if err := r.reload(); err != nil {
+				// Keep serving the previous certificate, the files may be part way through being replaced
+				r.logger.Error("Unable to reload TLS certificate", "cert_file", r.certFile, "error", err.Error())
+			}
+		}
+	}
+}
+
+// reload loads the certificate if either file has been modified since it was last loaded.
+func (r *CertReloader) reload() error {
+	var modTimes [2]time.Time
+	for i, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		modTimes[i] = info.ModTime()
+	}
+
+	r.mu.RLock()
+	unchanged := r.cert != nil && modTimes == r.modTimes
+	r.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cert != nil {
+		r.logger.Info("Reloaded TLS certificate", "cert_file", r.certFile)
+	}
+	r.cert = &cert
+	r.modTimes = modTimes
+
+	return nil
+}
+
+// LoadCertPool reads a bundle of PEM encoded CA certificates.
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in %v", caFile)
+	}
+
+	return pool, nil
+}
+
+// ===== CLIENT IDENTITY ===============================================================================================
+
+// ClientIdentity describes a client that authenticated with a verified TLS client certificate.
+type ClientIdentity struct {
+	CommonName  string
+	DNSNames    []string
+	URIs        []*url.URL
+	Certificate *x509.Certificate
+}
+
+// ClientIdentityFromRequest returns the identity from the request's verified client certificate, or nil if the client
+// didn't present one. Certificates that weren't verified against the configured CA bundle are ignored.
+func ClientIdentityFromRequest(r *http.Request) *ClientIdentity {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	return &ClientIdentity{
+		CommonName:  cert.Subject.CommonName,
+		DNSNames:    cert.DNSNames,
+		URIs:        cert.URIs,
+		Certificate: cert,
+	}
+}
+
+// ===== CLIENT TLS ====================================================================================================
+
+// LoadClientTLSConfig builds a TLS configuration for a Client. caFile, if given, replaces the system roots used to
+// verify the server. certFile and keyFile, if given, are presented as the client certificate.
+func LoadClientTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pool, err := LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("A client certificate needs both a certificate and a key file")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
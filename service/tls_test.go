@@ -0,0 +1,115 @@
+package service_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/circleci/cci-demo-docker/service"
+	"github.com/circleci/cci-demo-docker/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MutualTLS(t *testing.T) {
+	// SETUP: A server requiring client certificates from the test CA.
+	dir, err := ioutil.TempDir("", "contacts-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := test.NewCA(t, dir)
+	serverCert, serverKey := ca.Issue("server", "contacts")
+	clientCert, clientKey := ca.Issue("client", "billing-service")
+
+	env := test.SetupEnv(t)
+	defer env.Close()
+
+	reloader, err := service.NewCertReloader(serverCert, serverKey, time.Minute, nil)
+	require.NoError(t, err)
+	defer reloader.Close()
+	clientCAs, err := service.LoadCertPool(ca.CertFile)
+	require.NoError(t, err)
+
+	var identity *service.ClientIdentity
+	tlsServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity = service.ClientIdentityFromRequest(r)
+		env.Server.ServeHTTP(w, r)
+	}))
+	tlsServer.TLS = &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		ClientCAs:      clientCAs,
+		ClientAuth:     tls.RequireAndVerifyClientCert,
+	}
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	// TEST: A client presenting its certificate.
+	{
+		tlsConfig, err := service.LoadClientTLSConfig(ca.CertFile, clientCert, clientKey)
+		require.NoError(t, err)
+		client := service.NewClient(tlsServer.URL, service.WithTLSConfig(tlsConfig), service.WithAPIKey(env.CreateAPIKey(service.DefaultAccountID, test.AllScopes...)))
+
+		_, err = client.AddContact(service.AddContactRequest{Email: "alice@example.xyz", Name: "Alice Zulu"})
+
+		// VERIFY: The request succeeds, and the handler sees who made it.
+		require.NoError(t, err)
+		require.NotNil(t, identity)
+		assert.Equal(t, "billing-service", identity.CommonName)
+	}
+
+	// TEST: A client without a certificate.
+	{
+		tlsConfig, err := service.LoadClientTLSConfig(ca.CertFile, "", "")
+		require.NoError(t, err)
+		client := service.NewClient(tlsServer.URL, service.WithTLSConfig(tlsConfig))
+
+		_, err = client.GetContactByEmail("alice@example.xyz")
+
+		// VERIFY: The handshake is rejected.
+		assert.Error(t, err)
+	}
+}
+
+func Test_CertReloader(t *testing.T) {
+	// SETUP:
+	dir, err := ioutil.TempDir("", "contacts-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := test.NewCA(t, dir)
+	certFile, keyFile := ca.Issue("server", "first")
+
+	var buf bytes.Buffer
+	reloader, err := service.NewCertReloader(certFile, keyFile, 10*time.Millisecond, slog.New(slog.NewJSONHandler(&buf, nil)))
+	require.NoError(t, err)
+	defer reloader.Close()
+
+	cert, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	first := cert.Certificate[0]
+
+	// TEST: Rotating the certificate on disk.
+	time.Sleep(20 * time.Millisecond) // Ensure the modification time changes
+	ca.Issue("server", "second")
+
+	// VERIFY: The new certificate is served without a restart.
+	rotated := false
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline) && !rotated; time.Sleep(10 * time.Millisecond) {
+		cert, err = reloader.GetCertificate(nil)
+		require.NoError(t, err)
+		rotated = string(cert.Certificate[0]) != string(first)
+	}
+	assert.True(t, rotated, "Certificate was not reloaded")
+
+	// VERIFY: The reload is logged.
+	lines := logLines(t, &buf)
+	require.NotEmpty(t, lines)
+	assert.Equal(t, "Reloaded TLS certificate", lines[0]["msg"])
+	assert.Equal(t, certFile, lines[0]["cert_file"])
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/circleci/cci-demo-docker/tracing"
+)
+
+// traceRequest records a span for each request, continuing the trace of the caller if it sent a traceparent header.
+// It must be called within accessLog, which records the route and status that the span is named and labelled with.
+func (s *Server) traceRequest(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	ctx := tracing.Extract(r.Context(), r.Header)
+	ctx, span := tracing.Start(
+		ctx,
+		r.Method,
+		tracing.KindServer,
+		tracing.String("http.request.method", r.Method),
+		tracing.String("url.path", r.URL.Path),
+	)
+	if span == nil {
+		next(w, r)
+		return
+	}
+	if info, ok := r.Context().Value(requestInfoKey).(*requestInfo); ok {
+		info.traceID = span.SpanContext().TraceID.String()
+	}
+
+	defer func() {
+		if route := routePattern(r); route != "" {
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(tracing.String("http.route", route))
+		}
+
+		if recorder, ok := w.(*statusRecorder); ok {
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			span.SetAttributes(tracing.Int("http.response.status_code", status))
+			if status >= http.StatusInternalServerError {
+				span.SetError(fmt.Errorf("%v", http.StatusText(status)))
+			}
+		}
+
+		span.End()
+	}()
+
+	next(w, r.WithContext(ctx))
+}
@@ -0,0 +1,66 @@
+package service_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/circleci/cci-demo-docker/service"
+	"github.com/circleci/cci-demo-docker/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// spanRecorder is an Exporter that keeps every span.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *spanRecorder) ExportSpans(ctx context.Context, spans []tracing.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *spanRecorder) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func Test_TracePropagation(t *testing.T) {
+	// SETUP:
+	recorder := &spanRecorder{}
+	tracer := tracing.NewTracer(recorder)
+	tracing.SetDefault(tracer)
+	defer tracing.SetDefault(nil)
+
+	httpServer := httptest.NewServer(service.NewServer(nil))
+	client := service.NewClient(httpServer.URL).(*service.DefaultClient)
+
+	// TEST: A request made within a span, which fails without reaching the database.
+	ctx, root := tracer.Start(context.Background(), "root", tracing.KindInternal)
+	_, err := client.WithContext(ctx).GetContactByEmail(" ")
+	root.End()
+	require.Error(t, err)
+
+	// The server's span ends after the response is sent, closing the server waits for it.
+	httpServer.Close()
+	require.NoError(t, tracer.Flush(context.Background()))
+
+	// VERIFY: The server's span is a child of the client's, which is a child of the root.
+	spans := map[tracing.SpanKind]tracing.SpanData{}
+	for _, span := range recorder.spans {
+		spans[span.Kind] = span
+	}
+	require.Len(t, recorder.spans, 3)
+
+	clientSpan, serverSpan := spans[tracing.KindClient], spans[tracing.KindServer]
+	assert.Equal(t, root.SpanContext().SpanID, clientSpan.Parent)
+	assert.Equal(t, clientSpan.SpanID, serverSpan.Parent)
+	assert.Equal(t, root.SpanContext().TraceID, serverSpan.TraceID)
+	assert.Equal(t, "GET /v1/contacts/:email", serverSpan.Name)
+	assert.Contains(t, serverSpan.Attributes, tracing.Int("http.response.status_code", 400))
+	assert.Contains(t, clientSpan.Attributes, tracing.Int("http.response.status_code", 400))
+}
@@ -1,6 +1,7 @@
 package test
 
 import (
+	"context"
 	"github.com/circleci/cci-demo-docker/service"
 	"github.com/stretchr/testify/require"
 )
@@ -20,10 +21,15 @@ func (env *Env) SetupContact(email string, name string) *service.Contact {
 	return contact
 }
 
-// ReadContactWithEmail reads a contact from the test database with the given email. Helpers like this make it easy to
-// verify the state of the database as part of a test.
+// ReadContactWithEmail reads a contact of the default account from the test database with the given email. Helpers like
+// this make it easy to verify the state of the database as part of a test.
 func (env *Env) ReadContactWithEmail(email string) *service.Contact {
-	contact, err := env.DB.GetContactByEmail(email)
+	return env.ReadAccountContactWithEmail(service.DefaultAccountID, email)
+}
+
+// ReadAccountContactWithEmail reads a contact of the account from the test database with the given email.
+func (env *Env) ReadAccountContactWithEmail(accountID int, email string) *service.Contact {
+	contact, err := env.DB.GetContactByEmail(service.WithAccount(context.Background(), accountID), email)
 	require.NoError(env.T, err)
 
 	return contact
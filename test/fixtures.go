@@ -0,0 +1,27 @@
+package test
+
+import (
+	"context"
+	"path/filepath"
+	"runtime"
+
+	"github.com/circleci/cci-demo-docker/service"
+	"github.com/stretchr/testify/require"
+)
+
+// LoadFixtures inserts the records from a YAML or JSON fixtures file, returning them keyed by ref. Relative paths are
+// resolved against the test/fixtures directory, so tests in any package can share fixtures.
+func (env *Env) LoadFixtures(path string) *service.SeededFixtures {
+	if !filepath.IsAbs(path) {
+		_, thisFile, _, _ := runtime.Caller(0)
+		path = filepath.Join(filepath.Dir(thisFile), "fixtures", path)
+	}
+
+	fixtures, err := service.ReadFixtures(path)
+	require.NoError(env.T, err, "Unable to read fixtures")
+
+	seeded, err := env.DB.LoadFixtures(context.Background(), fixtures, false)
+	require.NoError(env.T, err, "Unable to load fixtures")
+
+	return seeded
+}
@@ -0,0 +1,161 @@
+package test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Issuer issues JWTs for tests, like an OIDC provider would. Its keys are generated locally, and their public halves
+// are written to a JWKS file.
+type Issuer struct {
+	T        *testing.T
+	URL      string
+	Audience string
+	JWKSFile string
+	keys     []*issuerKey
+}
+
+type issuerKey struct {
+	kid string
+	alg string
+	key crypto.Signer
+}
+
+// NewIssuer creates an Issuer with an RS256 key and an ES256 key, writing its JWKS to dir.
+func NewIssuer(t *testing.T, dir string) *Issuer {
+	issuer := &Issuer{
+		T:        t,
+		URL:      "https://issuer.example.xyz",
+		Audience: "contacts",
+		JWKSFile: filepath.Join(dir, "jwks.json"),
+	}
+	issuer.AddKey("RS256")
+	issuer.AddKey("ES256")
+	return issuer
+}
+
+// AddKey generates a key for the algorithm, RS256 or ES256, rewriting the JWKS file. Its key ID is returned.
+func (i *Issuer) AddKey(alg string) string {
+	var key crypto.Signer
+	var err error
+	switch alg {
+	case "RS256":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		i.T.Fatalf("Unsupported algorithm %v", alg)
+	}
+	require.NoError(i.T, err)
+
+	kid := fmt.Sprintf("%v-%d", strings.ToLower(alg), len(i.keys)+1)
+	i.keys = append(i.keys, &issuerKey{kid: kid, alg: alg, key: key})
+	require.NoError(i.T, ioutil.WriteFile(i.JWKSFile, i.JWKS(), 0644))
+	return kid
+}
+
+// RemoveKey removes a key from the JWKS file, as when the issuer rotates it out.
+func (i *Issuer) RemoveKey(kid string) {
+	for n, key := range i.keys {
+		if key.kid == kid {
+			i.keys = append(i.keys[:n], i.keys[n+1:]...)
+		}
+	}
+	require.NoError(i.T, ioutil.WriteFile(i.JWKSFile, i.JWKS(), 0644))
+}
+
+// JWKS returns the public keys as a JSON Web Key Set.
+func (i *Issuer) JWKS() []byte {
+	encode := func(n *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(n.Bytes())
+	}
+
+	keys := []map[string]string{}
+	for _, k := range i.keys {
+		switch public := k.key.Public().(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, map[string]string{
+				"kty": "RSA", "kid": k.kid, "alg": k.alg, "use": "sig",
+				"n": encode(public.N), "e": encode(big.NewInt(int64(public.E))),
+			})
+		case *ecdsa.PublicKey:
+			keys = append(keys, map[string]string{
+				"kty": "EC", "kid": k.kid, "alg": k.alg, "use": "sig", "crv": "P-256",
+				"x": encode(public.X), "y": encode(public.Y),
+			})
+		}
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	require.NoError(i.T, err)
+	return data
+}
+
+// Claims returns valid claims for subject, granting scopes, for an hour.
+func (i *Issuer) Claims(subject string, scopes ...string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":   i.URL,
+		"aud":   i.Audience,
+		"sub":   subject,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"scope": strings.Join(scopes, " "),
+	}
+}
+
+// Token returns a token for subject granting scopes, signed with the first key.
+func (i *Issuer) Token(subject string, scopes ...string) string {
+	return i.Sign(i.keys[0].kid, i.Claims(subject, scopes...))
+}
+
+// Sign signs claims with the key with the given ID.
+func (i *Issuer) Sign(kid string, claims map[string]interface{}) string {
+	var k *issuerKey
+	for _, key := range i.keys {
+		if key.kid == kid {
+			k = key
+		}
+	}
+	require.NotNil(i.T, k, "Unknown key %v", kid)
+
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		require.NoError(i.T, err)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	signed := encode(map[string]string{"alg": k.alg, "kid": k.kid, "typ": "JWT"}) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := k.key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		require.NoError(i.T, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		require.NoError(i.T, err)
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
@@ -0,0 +1,30 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/circleci/cci-demo-docker/service"
+)
+
+// ValidateResponses wraps handler to fail the test when a response doesn't match the OpenAPI spec served at
+// /openapi.json, for example because a status code or field isn't documented.
+func ValidateResponses(t *testing.T, handler http.Handler) http.Handler {
+	spec := service.DefaultSpec()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+
+		if err := spec.ValidateResponse(r, recorder.Code, recorder.Header(), recorder.Body.Bytes()); err != nil {
+			t.Errorf("Response doesn't match the OpenAPI spec: %v", err)
+		}
+
+		for key, values := range recorder.Header() {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(recorder.Code)
+		w.Write(recorder.Body.Bytes())
+	})
+}
@@ -1,14 +1,14 @@
 package test
 
 import (
+	"context"
 	"database/sql"
 	"net/http/httptest"
-	"os"
 	"testing"
 
+	"github.com/circleci/cci-demo-docker/config"
+	"github.com/circleci/cci-demo-docker/db"
 	"github.com/circleci/cci-demo-docker/service"
-	_ "github.com/mattes/migrate/driver/postgres"
-	"github.com/mattes/migrate/migrate"
 	"github.com/stretchr/testify/require"
 )
 
@@ -28,34 +28,74 @@ func (env *Env) Close() {
 	env.DB.Close()
 }
 
-// SetupEnv creates a new test environment, including a clean database and an instance of our HTTP service.
+// SetupEnv creates a new test environment, including a clean database and an instance of our HTTP service. Every
+// response of the service is checked against its OpenAPI spec. The service requires API keys, and the Client
+// authenticates with one of the default account granting every scope.
 func SetupEnv(t *testing.T) *Env {
 	db := SetupDB(t)
-	server := service.NewServer(db)
-	httpServer := httptest.NewServer(server)
-	return &Env{
+	server := service.NewServer(db, service.WithAuthentication(&service.APIKeyAuthenticator{DB: db}))
+	httpServer := httptest.NewServer(ValidateResponses(t, server))
+	env := &Env{
 		T:          t,
 		DB:         db,
 		Server:     server,
 		HttpServer: httpServer,
-		Client:     service.NewClient(httpServer.URL),
 	}
+	env.Client = service.NewClient(httpServer.URL, service.WithAPIKey(env.CreateAPIKey(service.DefaultAccountID, AllScopes...)))
+	return env
 }
 
-// SetupDB initializes a test database, performing all migrations.
+// AllScopes are granted to the API key of the Env's Client.
+var AllScopes = []string{"contacts:read", "contacts:write"}
+
+// CreateAPIKey creates an API key for the account with the given scopes, returning the key.
+func (env *Env) CreateAPIKey(accountID int, scopes ...string) string {
+	key, _, err := env.DB.CreateAPIKey(context.Background(), accountID, env.T.Name(), scopes, nil)
+	require.NoError(env.T, err, "Unable to create API key")
+	return key
+}
+
+// SetupDB initializes a test database, performing all migrations with CONTACTS_DB_MIGRATION_URL. The service connects
+// with CONTACTS_DB_URL, which must be a member of contacts_app, so that the tests run with row-level security applied.
 func SetupDB(t *testing.T) *service.Database {
-	databaseUrl := os.Getenv("DATABASE_URL")
-	require.NotEmpty(t, databaseUrl, "DATABASE_URL must be set!")
+	cfg := Config(t)
 
-	sqlFiles := "./db/migrations"
-	if sqlFilesEnv := os.Getenv("DB_MIGRATIONS"); sqlFilesEnv != "" {
-		sqlFiles = sqlFilesEnv
-	}
-	allErrors, ok := migrate.ResetSync(databaseUrl, sqlFiles)
-	require.True(t, ok, "Failed to migrate database %v", allErrors)
+	err := db.Reset(cfg.DB.OwnerURL(), cfg.DB.Migrations)
+	require.NoError(t, err, "Failed to migrate database")
+
+	sqlDB, err := sql.Open("postgres", cfg.DB.URL)
+	require.NoError(t, err, "Error opening database")
+	cfg.DB.ConfigurePool(sqlDB)
+	database := &service.Database{DB: sqlDB}
+
+	enforced, err := database.CheckRowLevelSecurity(context.Background())
+	require.NoError(t, err)
+	require.True(t, enforced, "CONTACTS_DB_URL must connect as a member of contacts_app, and CONTACTS_DB_MIGRATION_URL as the owner of the tables")
 
-	db, err := sql.Open("postgres", databaseUrl)
+	return database
+}
+
+// OwnerDB opens a connection as the role that owns the tables, for tests that change the schema or bypass the service.
+func OwnerDB(t *testing.T) *sql.DB {
+	sqlDB, err := sql.Open("postgres", Config(t).DB.OwnerURL())
 	require.NoError(t, err, "Error opening database")
+	return sqlDB
+}
+
+// Config loads the test configuration in the same way as the contacts binary, from the file named by CONTACTS_CONFIG
+// and from environment variables like CONTACTS_DB_URL.
+func Config(t *testing.T) *config.Config {
+	cfg, err := (&config.Loader{}).Load()
+	require.NoError(t, err, "Invalid test configuration")
+	require.NoError(t, cfg.RequireDB(), "CONTACTS_DB_URL must be set!")
+
+	return cfg
+}
+
+// SetupAccount creates an account, and a Client authenticating as a member of it with every scope.
+func (env *Env) SetupAccount(name string) (*service.Account, service.Client) {
+	account, err := env.DB.CreateAccount(context.Background(), name)
+	require.NoError(env.T, err, "Unable to create account")
 
-	return &service.Database{db}
+	return account, service.NewClient(env.HttpServer.URL, service.WithAPIKey(env.CreateAPIKey(account.Id, AllScopes...)))
 }
@@ -0,0 +1,232 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// ===== WRITER EXPORTER ===============================================================================================
+
+// WriterExporter writes each batch of spans as a line of OTLP JSON, the format of the OpenTelemetry Collector's file
+// exporter. It is intended for local development and tests.
+type WriterExporter struct {
+	serviceName string
+
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewWriterExporter creates a WriterExporter writing to w, e.g. os.Stdout.
+func NewWriterExporter(serviceName string, w io.Writer) *WriterExporter {
+	return &WriterExporter{serviceName: serviceName, w: w}
+}
+
+// NewFileExporter creates a WriterExporter appending to the file at path, which is closed by Shutdown.
+func NewFileExporter(serviceName string, path string) (*WriterExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open trace file: %v", err)
+	}
+
+	return &WriterExporter{serviceName: serviceName, w: f, closer: f}, nil
+}
+
+func (e *WriterExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	line, err := json.Marshal(encodeOTLP(e.serviceName, spans))
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.w.Write(append(line, '\n'))
+	return err
+}
+
+func (e *WriterExporter) Shutdown(ctx context.Context) error {
+	if e.closer != nil {
+		return e.closer.Close()
+	}
+
+	return nil
+}
+
+// ===== OTLP EXPORTER =================================================================================================
+
+// OTLPExporter sends spans to an OpenTelemetry collector using OTLP over HTTP, with JSON encoding.
+type OTLPExporter struct {
+	serviceName string
+	endpoint    string
+	headers     map[string]string
+	client      *http.Client
+}
+
+// NewOTLPExporter creates an OTLPExporter posting to endpoint, the full URL of the collector's traces receiver, e.g.
+// http://localhost:4318/v1/traces. The headers are sent with every request, e.g. for authentication.
+func NewOTLPExporter(serviceName string, endpoint string, headers map[string]string) *OTLPExporter {
+	return &OTLPExporter{
+		serviceName: serviceName,
+		endpoint:    endpoint,
+		headers:     headers,
+		client:      &http.Client{},
+	}
+}
+
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(encodeOTLP(e.serviceName, spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	response, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("OTLP endpoint responded %v: %s", response.Status, bytes.TrimSpace(message))
+	}
+
+	return nil
+}
+
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+// ===== OTLP JSON =====================================================================================================
+
+// The types below are the parts of the OTLP JSON encoding used here, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding.
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Status codes of OTLP spans.
+const (
+	otlpStatusUnset = 0
+	otlpStatusError = 2
+)
+
+type otlpAttribute struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func encodeOTLP(serviceName string, spans []SpanData) otlpTraces {
+	encoded := make([]otlpSpan, len(spans))
+	for i, span := range spans {
+		encoded[i] = otlpSpan{
+			TraceID:           span.TraceID.String(),
+			SpanID:            span.SpanID.String(),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        encodeAttributes(span.Attributes),
+			Status:            otlpStatus{Code: otlpStatusUnset},
+		}
+		if span.Parent != (SpanID{}) {
+			encoded[i].ParentSpanID = span.Parent.String()
+		}
+		if span.Error != "" {
+			encoded[i].Status = otlpStatus{Code: otlpStatusError, Message: span.Error}
+		}
+	}
+
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: encodeAttributes([]Attribute{String("service.name", serviceName)})},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/circleci/cci-demo-docker"},
+			Spans: encoded,
+		}},
+	}}}
+}
+
+func encodeAttributes(attributes []Attribute) []otlpAttribute {
+	encoded := make([]otlpAttribute, 0, len(attributes))
+	for _, attribute := range attributes {
+		var value otlpAnyValue
+		switch v := attribute.Value.(type) {
+		case string:
+			value.StringValue = &v
+		case bool:
+			value.BoolValue = &v
+		case int64:
+			s := strconv.FormatInt(v, 10)
+			value.IntValue = &s
+		case float64:
+			value.DoubleValue = &v
+		default:
+			s := fmt.Sprint(v)
+			value.StringValue = &s
+		}
+		encoded = append(encoded, otlpAttribute{Key: attribute.Key, Value: value})
+	}
+
+	return encoded
+}
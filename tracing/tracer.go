@@ -0,0 +1,193 @@
+package tracing
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Exporter sends finished spans somewhere, like an OTLP collector or a file.
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// Batching limits of a Tracer. Spans are exported once a batch is full, or after BatchTimeout, whichever is first.
+// Spans are dropped, rather than blocking requests, once MaxQueueSize are waiting to be exported.
+const (
+	MaxBatchSize = 512
+	MaxQueueSize = 2048
+	BatchTimeout = 5 * time.Second
+)
+
+// Tracer starts spans, and exports them in batches from a background goroutine.
+type Tracer struct {
+	exporter Exporter
+
+	queue    chan SpanData
+	flushes  chan chan struct{}
+	shutdown chan struct{}
+	done     chan struct{}
+	once     sync.Once
+	dropped  uint64
+}
+
+// NewTracer creates a Tracer that exports spans with exporter. Call Shutdown to export the remaining spans before
+// exiting.
+func NewTracer(exporter Exporter) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		queue:    make(chan SpanData, MaxQueueSize),
+		flushes:  make(chan chan struct{}),
+		shutdown: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go t.run()
+	return t
+}
+
+var defaultTracer atomic.Value // **Tracer, so that a nil Tracer can be stored
+
+// SetDefault sets the Tracer used by Start. A nil Tracer disables tracing.
+func SetDefault(t *Tracer) {
+	defaultTracer.Store(&t)
+}
+
+// Default returns the Tracer used by Start, or nil if tracing is disabled.
+func Default() *Tracer {
+	t, _ := defaultTracer.Load().(**Tracer)
+	if t == nil {
+		return nil
+	}
+
+	return *t
+}
+
+// Start starts a span as a child of the span in ctx, or of the remote parent extracted from a request. Spans of
+// remote parents that weren't sampled aren't sampled either.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind, attributes ...Attribute) (context.Context, *Span) {
+	parent := SpanContextFromContext(ctx)
+
+	data := SpanData{
+		SpanContext: SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), Sampled: parent.Sampled},
+		Parent:      parent.SpanID,
+		Name:        name,
+		Kind:        kind,
+		Start:       time.Now(),
+		Attributes:  attributes,
+	}
+	if !parent.IsValid() {
+		data.TraceID = newTraceID()
+		data.Parent = SpanID{}
+		data.Sampled = true
+	}
+
+	span := &Span{tracer: t, data: data}
+	return ContextWithSpan(ctx, span), span
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	select {
+	case <-t.shutdown:
+		return
+	default:
+	}
+
+	select {
+	case t.queue <- data:
+	default:
+		if atomic.AddUint64(&t.dropped, 1)%MaxQueueSize == 1 {
+			slog.Warn("Dropping spans, the export queue is full", "dropped", atomic.LoadUint64(&t.dropped))
+		}
+	}
+}
+
+// Flush exports every span that has ended, waiting until they have been sent or ctx is done.
+func (t *Tracer) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case t.flushes <- flushed:
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown exports the remaining spans and shuts down the exporter. Spans that end afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.once.Do(func() { close(t.shutdown) })
+
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return t.exporter.Shutdown(ctx)
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	timer := time.NewTimer(BatchTimeout)
+	defer timer.Stop()
+
+	var batch []SpanData
+	export := func() {
+		if len(batch) > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), BatchTimeout)
+			if err := t.exporter.ExportSpans(ctx, batch); err != nil {
+				slog.Error("Unable to export spans", "spans", len(batch), "error", err.Error())
+			}
+			cancel()
+			batch = nil
+		}
+		timer.Reset(BatchTimeout)
+	}
+
+	// drain adds every queued span to the batch.
+	drain := func() {
+		for {
+			select {
+			case data := <-t.queue:
+				batch = append(batch, data)
+				if len(batch) >= MaxBatchSize {
+					export()
+				}
+			default:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= MaxBatchSize {
+				export()
+			}
+		case <-timer.C:
+			export()
+		case flushed := <-t.flushes:
+			drain()
+			export()
+			close(flushed)
+		case <-t.shutdown:
+			drain()
+			export()
+			return
+		}
+	}
+}
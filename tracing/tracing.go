@@ -0,0 +1,292 @@
+// Package tracing records spans describing the work done to handle a request, across the HTTP client, server and
+// database, and exports them to OTLP collectors or files. Spans are propagated between services with the W3C
+// traceparent header.
+//
+// Spans are started with Start, which uses the Tracer set with SetDefault. Nothing is recorded until a Tracer is set,
+// and every method of a nil *Span does nothing, so instrumented code doesn't have to check whether tracing is enabled.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies every span of a trace.
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID identifies a single span within a trace.
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext is the part of a span that is propagated to other services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// ===== PROPAGATION ===================================================================================================
+
+// TraceparentHeader carries the SpanContext of the caller, see https://www.w3.org/TR/trace-context/.
+const TraceparentHeader = "traceparent"
+
+// Traceparent formats sc as a traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%v-%v-%v", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a traceparent header value. Versions other than 00 are parsed as far as version 00 goes, as
+// the specification requires.
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("Invalid traceparent %q", value)
+	}
+
+	if err := decodeHex(parts[1], sc.TraceID[:]); err != nil {
+		return sc, fmt.Errorf("Invalid trace ID in traceparent %q", value)
+	}
+	if err := decodeHex(parts[2], sc.SpanID[:]); err != nil {
+		return sc, fmt.Errorf("Invalid span ID in traceparent %q", value)
+	}
+
+	var flags [1]byte
+	if err := decodeHex(parts[3], flags[:]); err != nil {
+		return sc, fmt.Errorf("Invalid flags in traceparent %q", value)
+	}
+	sc.Sampled = flags[0]&1 == 1
+
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("Invalid traceparent %q", value)
+	}
+
+	return sc, nil
+}
+
+func decodeHex(s string, dst []byte) error {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return fmt.Errorf("expected %v lowercase hex digits", hex.EncodedLen(len(dst)))
+	}
+
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+// Inject sets the traceparent header to the span in ctx, if there is one.
+func Inject(ctx context.Context, header http.Header) {
+	if sc := SpanContextFromContext(ctx); sc.IsValid() {
+		header.Set(TraceparentHeader, sc.Traceparent())
+	}
+}
+
+// Extract returns a copy of ctx whose spans are children of the span in the traceparent header, if it is valid.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, err := ParseTraceparent(header.Get(TraceparentHeader))
+	if err != nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, remoteKey, sc)
+}
+
+// ===== CONTEXT =======================================================================================================
+
+type contextKey int
+
+const (
+	spanKey contextKey = iota
+	remoteKey
+)
+
+// ContextWithSpan returns a copy of ctx in which span is the parent of new spans.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey, span)
+}
+
+// SpanFromContext returns the current span of ctx, or nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// SpanContextFromContext returns the SpanContext of the current span of ctx, or of the remote parent extracted from
+// a request if no span was started since.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.data.SpanContext
+	}
+
+	sc, _ := ctx.Value(remoteKey).(SpanContext)
+	return sc
+}
+
+// ===== SPANS =========================================================================================================
+
+// SpanKind describes the relationship between a span and its parent and children, as in OpenTelemetry.
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// Attribute is a key and a value, which is a string, bool, int64 or float64.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String creates a string Attribute.
+func String(key string, value string) Attribute {
+	return Attribute{key, value}
+}
+
+// Int creates an integer Attribute.
+func Int(key string, value int) Attribute {
+	return Attribute{key, int64(value)}
+}
+
+// Bool creates a boolean Attribute.
+func Bool(key string, value bool) Attribute {
+	return Attribute{key, value}
+}
+
+// SpanData is a finished span, as given to Exporters.
+type SpanData struct {
+	SpanContext
+	Parent     SpanID
+	Name       string
+	Kind       SpanKind
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	// Error is the message describing why the span failed, or "" if it didn't.
+	Error string
+}
+
+// Span is an operation being timed. Its methods are safe for concurrent use, and do nothing when the Span is nil.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// Start starts a span as a child of the span in ctx, using the default Tracer. The returned context carries the new
+// span. If no Tracer is set, ctx is returned as is with a nil *Span.
+func Start(ctx context.Context, name string, kind SpanKind, attributes ...Attribute) (context.Context, *Span) {
+	tracer := Default()
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	return tracer.Start(ctx, name, kind, attributes...)
+}
+
+// SpanContext returns the IDs of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	return s.data.SpanContext
+}
+
+// SetName replaces the name of the span, e.g. once the route of a request is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Name = name
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attributes...)
+}
+
+// SetError marks the span as failed, if err isn't nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+}
+
+// End finishes the span, queuing it for export if it is sampled. Calls after the first have no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.Sampled {
+		s.tracer.enqueue(data)
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(err)
+	}
+
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(err)
+	}
+
+	return id
+}
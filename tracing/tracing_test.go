@@ -0,0 +1,126 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/circleci/cci-demo-docker/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Traceparent(t *testing.T) {
+	sc, err := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	// VERIFY: Future versions may add fields.
+	_, err = tracing.ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	assert.NoError(t, err)
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+	} {
+		_, err := tracing.ParseTraceparent(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func Test_ExportSpans(t *testing.T) {
+	// SETUP:
+	var buf bytes.Buffer
+	tracer := tracing.NewTracer(tracing.NewWriterExporter("test", &buf))
+
+	header := http.Header{}
+	header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := tracing.Extract(context.Background(), header)
+
+	// TEST: A span continuing a remote trace, with a failed child.
+	ctx, parent := tracer.Start(ctx, "parent", tracing.KindServer, tracing.Int("answer", 42))
+	_, child := tracer.Start(ctx, "child", tracing.KindClient)
+	child.SetError(errors.New("boom"))
+	child.End()
+	parent.End()
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	// VERIFY: One batch of OTLP JSON was written.
+	var traces struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					SpanID       string `json:"spanId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Name         string `json:"name"`
+					Kind         int    `json:"kind"`
+					Attributes   []struct {
+						Key   string                 `json:"key"`
+						Value map[string]interface{} `json:"value"`
+					} `json:"attributes"`
+					Status struct {
+						Code    int    `json:"code"`
+						Message string `json:"message"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &traces))
+	spans := traces.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, 3, spans[0].Kind)
+	assert.Equal(t, spans[1].SpanID, spans[0].ParentSpanID)
+	assert.Equal(t, 2, spans[0].Status.Code)
+	assert.Equal(t, "boom", spans[0].Status.Message)
+
+	assert.Equal(t, "parent", spans[1].Name)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[1].TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", spans[1].ParentSpanID)
+	assert.Equal(t, "answer", spans[1].Attributes[0].Key)
+	assert.Equal(t, map[string]interface{}{"intValue": "42"}, spans[1].Attributes[0].Value)
+}
+
+func Test_UnsampledSpansAreNotExported(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := tracing.NewTracer(tracing.NewWriterExporter("test", &buf))
+
+	header := http.Header{}
+	header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	ctx, span := tracer.Start(tracing.Extract(context.Background(), header), "unsampled", tracing.KindServer)
+	span.End()
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	// VERIFY: The decision is still propagated.
+	outgoing := http.Header{}
+	tracing.Inject(ctx, outgoing)
+	assert.Regexp(t, "^00-4bf92f3577b34da6a3ce929d0e0e4736-[0-9a-f]{16}-00$", outgoing.Get(tracing.TraceparentHeader))
+	assert.Empty(t, buf.String())
+}
+
+func Test_NilSpan(t *testing.T) {
+	tracing.SetDefault(nil)
+
+	ctx, span := tracing.Start(context.Background(), "disabled", tracing.KindInternal)
+	span.SetAttributes(tracing.String("key", "value"))
+	span.SetError(errors.New("boom"))
+	span.End()
+
+	assert.Nil(t, span)
+	assert.False(t, tracing.SpanContextFromContext(ctx).IsValid())
+}